@@ -0,0 +1,255 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// ArchiveFormat is a file format Archive can write
+type ArchiveFormat string
+
+const (
+	FormatTar     ArchiveFormat = "tar"
+	FormatTarZstd ArchiveFormat = "tar.zst"
+	FormatZip     ArchiveFormat = "zip"
+)
+
+// ArchiveFormats lists the supported formats
+var ArchiveFormats = []ArchiveFormat{FormatTar, FormatTarZstd, FormatZip}
+
+// ArchiveOptions configures Archive
+type ArchiveOptions struct {
+	Connection
+
+	Domain string
+	ID     string // upload ID, latest if empty
+	Team   string // team to act for
+	Format ArchiveFormat
+
+	Parallelism int // concurrent part downloads (default: 3)
+}
+
+// archiveTime is the modification time of every entry, so archives of a bundle are identical;
+// zip cannot represent earlier times
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveEntry is a directory (without hash) or file of the tree
+type archiveEntry struct {
+	name string // slash-separated, directories ending with a slash
+	hash *protocol.Hash
+}
+
+// archiver writes entries in one of the formats
+type archiver interface {
+	dir(name string) error
+	file(name string, content []byte) error
+	Close() error
+}
+
+// Archive streams an upload of opts.Domain to w as an archive, its entries sorted by path
+func Archive(ctx context.Context, w io.Writer, opts ArchiveOptions) (*FetchResult, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if !slices.Contains(ArchiveFormats, opts.Format) {
+		return nil, fmt.Errorf("unknown archive format %q", opts.Format)
+	}
+	req := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}
+	parallelism := cmp.Or(opts.Parallelism, 3)
+
+	downloader, err := newDownloader(ctx, opts.Connection, parallelism, emit)
+	if err != nil {
+		return nil, err
+	}
+	node, hash, err := downloadTree(ctx, downloader.Client, req, opts.ID, emit)
+	if err != nil {
+		return nil, err
+	}
+	result := &FetchResult{Bundle: hash}
+
+	var entries []archiveEntry
+	if err := listEntries("", node, &entries); err != nil {
+		return nil, err
+	}
+	// Parts are requested in the order of their first use, and kept until their last one
+	uses := make(map[protocol.Hash]int)
+	var hashes []protocol.Hash
+	for _, e := range entries {
+		if e.hash == nil {
+			continue
+		}
+		if uses[*e.hash] == 0 {
+			hashes = append(hashes, *e.hash)
+		}
+		uses[*e.hash]++
+	}
+
+	a, err := newArchiver(w, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	f := &fetcher{downloader: downloader, emit: emit, req: req, batches: newBatcher(hashes)}
+	parts := make(map[protocol.Hash][]byte)
+	next := 0
+	// write archives the entries whose parts have arrived, stopping at the first still missing
+	write := func() error {
+		for ; next < len(entries); next++ {
+			e := entries[next]
+			if e.hash == nil {
+				if err := a.dir(e.name); err != nil {
+					return err
+				}
+				continue
+			}
+			content, found := parts[*e.hash]
+			if !found {
+				return nil
+			}
+			if err := a.file(e.name, content); err != nil {
+				return err
+			}
+			if uses[*e.hash]--; uses[*e.hash] == 0 {
+				delete(parts, *e.hash)
+			}
+			f.files.Add(1)
+			f.bytes.Add(int64(len(content)))
+			emit.emit(Event{Type: EventFile, Path: e.name, Bytes: int64(len(content))})
+		}
+		return nil
+	}
+
+	// Download a window of batches at a time, archiving as soon as each window completes
+	for {
+		var window [][]protocol.Hash
+		for range parallelism {
+			if batch := f.batches.next(); len(batch) > 0 {
+				window = append(window, batch)
+			}
+		}
+		if len(window) == 0 {
+			break
+		}
+		received := make([]map[protocol.Hash][]byte, len(window))
+		if err := parallelize(ctx, len(window), parallelism, func(ctx context.Context, i int) error {
+			var err error
+			received[i], err = f.fetch(ctx, window[i])
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		for _, r := range received {
+			maps.Copy(parts, r)
+		}
+		if err := write(); err != nil {
+			return nil, fmt.Errorf("writing archive: %w", err)
+		}
+	}
+	if err := write(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+	if err := a.Close(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+	result.Files = int(f.files.Load())
+	result.Bytes = f.bytes.Load()
+	emit.emit(Event{Type: EventDownloaded, Bundle: fmt.Sprintf("%x", hash), Files: result.Files, Bytes: result.Bytes})
+	return result, nil
+}
+
+// listEntries lists the directories and files under node, sorted by path
+func listEntries(p string, node *protocol.Node, entries *[]archiveEntry) error {
+	if node.Hash != nil {
+		*entries = append(*entries, archiveEntry{name: p, hash: node.Hash})
+		return nil
+	}
+	if p != "" {
+		*entries = append(*entries, archiveEntry{name: p + "/"})
+	}
+	for _, name := range slices.Sorted(maps.Keys(node.Children)) {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid file name %q in bundle", name)
+		}
+		if err := listEntries(path.Join(p, name), node.Children[name], entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newArchiver(w io.Writer, format ArchiveFormat) (archiver, error) {
+	switch format {
+	case FormatTarZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiver{Writer: tar.NewWriter(zw), compressor: zw}, nil
+	case FormatZip:
+		return zipArchiver{zip.NewWriter(w)}, nil
+	default:
+		return &tarArchiver{Writer: tar.NewWriter(w)}, nil
+	}
+}
+
+type tarArchiver struct {
+	*tar.Writer
+	compressor io.WriteCloser // nil for plain tar
+}
+
+func (a *tarArchiver) dir(name string) error {
+	return a.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: archiveTime})
+}
+
+func (a *tarArchiver) file(name string, content []byte) error {
+	if err := a.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content)), ModTime: archiveTime}); err != nil {
+		return err
+	}
+	_, err := a.Write(content)
+	return err
+}
+
+func (a *tarArchiver) Close() error {
+	if err := a.Writer.Close(); err != nil {
+		return err
+	}
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
+
+type zipArchiver struct {
+	*zip.Writer
+}
+
+func (a zipArchiver) dir(name string) error {
+	h := &zip.FileHeader{Name: name, Modified: archiveTime}
+	h.SetMode(os.ModeDir | 0755)
+	_, err := a.CreateHeader(h)
+	return err
+}
+
+func (a zipArchiver) file(name string, content []byte) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveTime}
+	h.SetMode(0644)
+	fw, err := a.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(content)
+	return err
+}
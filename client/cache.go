@@ -0,0 +1,131 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// cacheEntry remembers the hash of a file as long as its metadata is unchanged
+type cacheEntry struct {
+	Size    int64         `cbor:"1,keyasint"`
+	ModTime int64         `cbor:"2,keyasint"`
+	Inode   uint64        `cbor:"3,keyasint,omitempty"`
+	Hash    protocol.Hash `cbor:"4,keyasint"`
+}
+
+// hashCache maps absolute paths to the hashes of their contents, keyed on size, mtime and inode
+type hashCache struct {
+	path    string
+	rehash  bool
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	seen    map[string]bool
+	dirty   bool
+}
+
+// loadHashCache reads the cache at p; with rehash set, lookups always miss but results are still recorded
+func loadHashCache(p string, rehash bool) (*hashCache, error) {
+	c := &hashCache{
+		path:    p,
+		rehash:  rehash,
+		entries: make(map[string]cacheEntry),
+		seen:    make(map[string]bool),
+	}
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := cbor.Unmarshal(b, &c.entries); err != nil {
+		c.entries = make(map[string]cacheEntry)
+		return c, err
+	}
+	return c, nil
+}
+
+func entryFor(info os.FileInfo, hash protocol.Hash) cacheEntry {
+	return cacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   inode(info),
+		Hash:    hash,
+	}
+}
+
+func (c *hashCache) lookup(p string, info os.FileInfo) (protocol.Hash, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[p] = true
+	if c.rehash {
+		return protocol.Hash{}, false
+	}
+	entry, ok := c.entries[p]
+	if !ok || entry != entryFor(info, entry.Hash) {
+		return protocol.Hash{}, false
+	}
+	return entry.Hash, true
+}
+
+func (c *hashCache) store(p string, info os.FileInfo, hash protocol.Hash) {
+	// A file modified within the timestamp granularity could change again without its mtime moving
+	if time.Since(info.ModTime()) < 2*time.Second {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[p] = entryFor(info, hash)
+	c.dirty = true
+}
+
+// prune forgets files under directory that were not looked up since the cache was loaded
+func (c *hashCache) prune(directory string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := directory + string(filepath.Separator)
+	for p := range c.entries {
+		if strings.HasPrefix(p, prefix) && !c.seen[p] {
+			delete(c.entries, p)
+			c.dirty = true
+		}
+	}
+}
+
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	b, err := cbor.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".hashes-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
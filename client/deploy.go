@@ -0,0 +1,245 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"github.com/xmit-co/xmit/config"
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/zeebo/blake3"
+)
+
+// DeployOptions configures Deploy
+type DeployOptions struct {
+	Connection
+
+	Domain    string
+	Directory string
+	Team      string // team to act for (default: the team of the directory's xmit.toml/xmit.json, if any)
+
+	Parallelism     int // concurrent chunk uploads (default: 3)
+	HashParallelism int // concurrent file hashing workers (default: GOMAXPROCS)
+
+	CachePath string // hash cache file, none if empty
+	Rehash    bool   // ignore cached hashes
+
+	DryRun bool // stop after suggesting the bundle, reporting what would be uploaded
+}
+
+// DeployResult describes a deployed (or, for dry runs, planned) bundle
+type DeployResult struct {
+	Bundle protocol.Hash
+	Files  int   // files in the bundle
+	Parts  int   // unique contents in the bundle
+	Bytes  int64 // total size of unique contents
+
+	UploadedParts int   // parts sent, or that a dry run would send
+	UploadedBytes int64 // size of those parts
+
+	// Pending lists, for dry runs, the slash-separated paths that would be uploaded
+	Pending []string
+}
+
+// Deploy uploads opts.Directory to opts.Domain and makes it live
+func Deploy(ctx context.Context, opts DeployOptions) (*DeployResult, error) {
+	emit := emitter(opts.OnEvent)
+	directory := opts.Directory
+
+	// Discover upload URL
+	policy := opts.callPolicy()
+	emit.emit(Event{Type: EventDiscovering})
+	discovery, err := protocol.Discover(ctx, opts.URL, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover upload endpoint: %w", err)
+	}
+	emit.emit(Event{Type: EventDiscovery, URL: discovery.URL})
+
+	if opts.Key == "" {
+		return nil, fmt.Errorf("%w (API keys can be managed at %s)", ErrNoKey, discovery.APIKeyManagementURL)
+	}
+
+	req := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}
+	if req.Team == "" {
+		cfg, err := config.Load(directory)
+		if err != nil {
+			return nil, err
+		}
+		req.Team = cfg.Team
+	}
+
+	// Create parallel uploader
+	uploader, err := protocol.NewParallelUploader(ctx, discovery.URL, cmp.Or(opts.Parallelism, 3))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parallel uploader: %w", err)
+	}
+	uploader.CallPolicy = policy
+	uploader.Progress = func(p protocol.ChunkProgress) {
+		emit.emit(Event{Type: EventChunk, Status: string(p.Stage), Chunk: p.Index + 1, Chunks: p.Count, Parts: p.Parts, Bytes: int64(p.Bytes)})
+	}
+
+	var cache *hashCache
+	if opts.CachePath != "" {
+		cache, err = loadHashCache(opts.CachePath, opts.Rehash)
+		if err != nil {
+			emit.warn("ignoring hash cache: %v", err)
+		}
+	}
+
+	emit.emit(Event{Type: EventBundling, Path: directory})
+	b, err := ingest(ctx, directory, cmp.Or(opts.HashParallelism, runtime.GOMAXPROCS(0)), cache, emit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ingest: %w", err)
+	}
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			emit.warn("failed to save hash cache: %v", err)
+		}
+	}
+	bb, err := uploader.EncMode().Marshal(b.Node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	result := &DeployResult{
+		Bundle: blake3.Sum256(bb),
+		Parts:  len(b.parts),
+	}
+	for _, value := range b.parts {
+		result.Bytes += value.size
+		result.Files += len(value.paths)
+	}
+	bbh := result.Bundle
+	emit.emit(Event{Type: EventBundle, Bundle: fmt.Sprintf("%x", bbh), Files: result.Files, Parts: result.Parts, Bytes: result.Bytes})
+
+	var toUpload []protocol.Hash
+	seen := make(map[protocol.Hash]bool)
+	addMissing := func(missing []protocol.Hash) error {
+		for _, h := range missing {
+			if _, known := b.parts[h]; !known {
+				return fmt.Errorf("server requested unknown part %x", h)
+			}
+			if !seen[h] {
+				seen[h] = true
+				toUpload = append(toUpload, h)
+			}
+		}
+		return nil
+	}
+
+	suggestResp, err := uploader.SuggestBundle(ctx, req, bbh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest bundle: %w", err)
+	}
+	if err := emit.check("bundle suggestion", suggestResp.Response); err != nil {
+		return nil, err
+	}
+
+	if err := addMissing(suggestResp.Missing); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		planDryRun(emit, result, b, directory, suggestResp.Present, toUpload)
+		return result, nil
+	}
+
+	if !suggestResp.Present {
+		bundleResp, err := uploader.UploadBundle(ctx, req, bb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload: %w", err)
+		}
+		if err := emit.check("bundle upload", bundleResp.Response); err != nil {
+			return nil, err
+		}
+
+		if err := addMissing(bundleResp.Missing); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(toUpload) > 0 {
+		size := func(h protocol.Hash) int64 { return b.parts[h].size }
+
+		result.UploadedParts = len(toUpload)
+		for _, h := range toUpload {
+			result.UploadedBytes += size(h)
+		}
+		emit.emit(Event{Type: EventMissing, Parts: result.UploadedParts, Bytes: result.UploadedBytes})
+
+		// Sort toUpload by decreasing size
+		slices.SortFunc(toUpload, func(i, j protocol.Hash) int {
+			return cmp.Compare(size(j), size(i))
+		})
+
+		// Chunk toUpload into 10MB+ slices
+		chunks := chunkSlice(toUpload, size, 10*1024*1024)
+
+		// Upload chunks in parallel, reading their parts from disk only when each chunk starts
+		results := uploader.UploadChunksParallel(ctx, req, len(chunks), func(i int) ([][]byte, error) {
+			parts := make([][]byte, len(chunks[i]))
+			for j, h := range chunks[i] {
+				content, err := b.load(h)
+				if err != nil {
+					return nil, err
+				}
+				parts[j] = content
+			}
+			return parts, nil
+		})
+
+		// Check results
+		for _, result := range results {
+			if result.Err != nil {
+				return nil, fmt.Errorf("failed to upload chunk %d: %w", result.Index+1, result.Err)
+			}
+			if err := emit.check(fmt.Sprintf("missing parts upload for chunk %d", result.Index+1), result.Response.Response); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	finalizeResp, err := uploader.Finalize(ctx, req, bbh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize: %w", err)
+	}
+	if err := emit.check("finalization", finalizeResp.Response); err != nil {
+		return nil, err
+	}
+	emit.emit(Event{Type: EventFinalized, Bundle: fmt.Sprintf("%x", bbh)})
+	return result, nil
+}
+
+// planDryRun records what an upload would transfer without sending anything
+func planDryRun(emit emitter, result *DeployResult, b *ingestion, directory string, present bool, missing []protocol.Hash) {
+	status := ""
+	if !present && len(missing) == 0 {
+		// The server only lists missing parts once it knows the bundle, so assume the worst
+		status = "new"
+		for h := range b.parts {
+			missing = append(missing, h)
+		}
+	}
+	sizes := make(map[string]int64)
+	for _, h := range missing {
+		pt := b.parts[h]
+		result.UploadedBytes += pt.size
+		for _, p := range pt.paths {
+			if rel, err := filepath.Rel(directory, p); err == nil {
+				p = rel
+			}
+			p = filepath.ToSlash(p)
+			result.Pending = append(result.Pending, p)
+			sizes[p] = pt.size
+		}
+	}
+	result.UploadedParts = len(missing)
+	slices.Sort(result.Pending)
+	for _, p := range result.Pending {
+		emit.emit(Event{Type: EventPending, Path: p, Bytes: sizes[p]})
+	}
+	emit.emit(Event{Type: EventDryRun, Status: status, Files: len(result.Pending), Parts: result.UploadedParts, Bytes: result.UploadedBytes})
+}
@@ -0,0 +1,339 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/xmit-co/xmit/config"
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/xmit-co/xmit/textdiff"
+	"github.com/zeebo/blake3"
+)
+
+// ChangeKind tells how a file differs between two trees
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+)
+
+// Change is a file that differs between two trees
+type Change struct {
+	Path     string // slash-separated, relative to the root
+	Kind     ChangeKind
+	Old, New *protocol.Hash // contents on each side, nil where the file is absent
+
+	// Diff is the unified diff of text contents, when requested and not too large
+	Diff string
+}
+
+// UploadRef designates an upload of a domain
+type UploadRef struct {
+	Domain string
+	ID     string // latest if empty
+}
+
+// DiffOptions configures Diff
+type DiffOptions struct {
+	Connection
+
+	Domain    string
+	ID        string // upload ID, latest if empty
+	Directory string
+	Team      string // team to act for (default: the team of the directory's xmit.toml/xmit.json, if any)
+
+	Content         bool // download changed text files to diff them
+	Parallelism     int  // concurrent part downloads (default: 3)
+	HashParallelism int  // concurrent file hashing workers (default: GOMAXPROCS)
+
+	CachePath string // hash cache file, none if empty
+	Rehash    bool   // ignore cached hashes
+}
+
+// DiffUploadsOptions configures DiffUploads
+type DiffUploadsOptions struct {
+	Connection
+
+	Team     string // team to act for
+	Old, New UploadRef
+
+	Content     bool // download changed text files to diff them
+	Parallelism int  // concurrent part downloads (default: 3)
+}
+
+// DiffResult lists the files differing between two trees
+type DiffResult struct {
+	Old, New protocol.Hash // bundles compared; for local diffs, New is the one the directory would upload as
+	Changes  []Change      // sorted by path
+}
+
+// contents loads the parts of hashes that can be diffed, leaving out binary and oversized ones
+type contents func(ctx context.Context, hashes []protocol.Hash) (map[protocol.Hash][]byte, error)
+
+// Diff compares opts.Directory to an upload of opts.Domain: files only present locally are Added.
+// Unless opts.Content is set, only the bundle of the upload is downloaded.
+func Diff(ctx context.Context, opts DiffOptions) (*DiffResult, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	req := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}
+	if req.Team == "" {
+		cfg, err := config.Load(opts.Directory)
+		if err != nil {
+			return nil, err
+		}
+		req.Team = cfg.Team
+	}
+
+	downloader, err := newDownloader(ctx, opts.Connection, cmp.Or(opts.Parallelism, 3), emit)
+	if err != nil {
+		return nil, err
+	}
+	remote, remoteHash, err := downloadTree(ctx, downloader.Client, req, opts.ID, emit)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache *hashCache
+	if opts.CachePath != "" {
+		cache, err = loadHashCache(opts.CachePath, opts.Rehash)
+		if err != nil {
+			emit.warn("ignoring hash cache: %v", err)
+		}
+	}
+	emit.emit(Event{Type: EventBundling, Path: opts.Directory})
+	b, err := ingest(ctx, opts.Directory, cmp.Or(opts.HashParallelism, runtime.GOMAXPROCS(0)), cache, emit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ingest: %w", err)
+	}
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			emit.warn("failed to save hash cache: %v", err)
+		}
+	}
+	bb, err := downloader.EncMode().Marshal(b.Node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	result := &DiffResult{
+		Old:     remoteHash,
+		New:     blake3.Sum256(bb),
+		Changes: diffTrees(remote, &b.Node),
+	}
+	if opts.Content {
+		if err := diffContents(ctx, result.Changes, remoteContents(downloader, req, cmp.Or(opts.Parallelism, 3), emit), b.textContents); err != nil {
+			return nil, err
+		}
+	}
+	emitChanges(emit, result.Changes)
+	return result, nil
+}
+
+// DiffUploads compares two uploads: files only present in opts.New are Added.
+// Unless opts.Content is set, only their bundles are downloaded.
+func DiffUploads(ctx context.Context, opts DiffUploadsOptions) (*DiffResult, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	oldReq := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Old.Domain}
+	newReq := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.New.Domain}
+
+	downloader, err := newDownloader(ctx, opts.Connection, cmp.Or(opts.Parallelism, 3), emit)
+	if err != nil {
+		return nil, err
+	}
+	oldTree, oldHash, err := downloadTree(ctx, downloader.Client, oldReq, opts.Old.ID, emit)
+	if err != nil {
+		return nil, err
+	}
+	newTree, newHash, err := downloadTree(ctx, downloader.Client, newReq, opts.New.ID, emit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiffResult{
+		Old:     oldHash,
+		New:     newHash,
+		Changes: diffTrees(oldTree, newTree),
+	}
+	if opts.Content {
+		if err := diffContents(ctx, result.Changes, remoteContents(downloader, oldReq, cmp.Or(opts.Parallelism, 3), emit), remoteContents(downloader, newReq, cmp.Or(opts.Parallelism, 3), emit)); err != nil {
+			return nil, err
+		}
+	}
+	emitChanges(emit, result.Changes)
+	return result, nil
+}
+
+// remoteContents loads parts from the domain of req. Bundles do not record sizes, so every part
+// is downloaded, but only those that can be diffed are kept.
+func remoteContents(downloader *protocol.ParallelDownloader, req protocol.Request, parallelism int, emit emitter) contents {
+	return func(ctx context.Context, hashes []protocol.Hash) (map[protocol.Hash][]byte, error) {
+		f := &fetcher{downloader: downloader, emit: emit, req: req, batches: newBatcher(hashes)}
+		var mu sync.Mutex
+		parts := make(map[protocol.Hash][]byte)
+		err := f.fetchAll(ctx, parallelism, func(ctx context.Context, batch []protocol.Hash) error {
+			received, err := f.fetch(ctx, batch)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for h, content := range received {
+				if diffable(content) {
+					parts[h] = content
+				}
+			}
+			return nil
+		})
+		return parts, err
+	}
+}
+
+// textExtensions are the extensions of files whose changes are worth showing line by line
+var textExtensions = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true, ".cjs": true,
+	".json": true, ".xml": true, ".svg": true, ".txt": true, ".md": true, ".webmanifest": true,
+}
+
+// maxDiffSize bounds the size of each side of a content diff
+const maxDiffSize = 1 << 20
+
+// diffable tells whether content is small enough text to diff
+func diffable(content []byte) bool {
+	return len(content) <= maxDiffSize && utf8.Valid(content)
+}
+
+// diffContents fills in the diffs of changed text files, loading their contents from old and new.
+// New contents are loaded first, so old ones are only loaded where there is something to diff them with.
+func diffContents(ctx context.Context, changes []Change, old, new contents) error {
+	var selected []int
+	newHashes := make(map[protocol.Hash]bool)
+	for i, c := range changes {
+		if !textExtensions[strings.ToLower(path.Ext(c.Path))] {
+			continue
+		}
+		selected = append(selected, i)
+		if c.New != nil {
+			newHashes[*c.New] = true
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	newParts, err := new(ctx, slices.Collect(maps.Keys(newHashes)))
+	if err != nil {
+		return err
+	}
+	oldHashes := make(map[protocol.Hash]bool)
+	for _, i := range selected {
+		c := changes[i]
+		if c.Old == nil {
+			continue
+		}
+		if c.New != nil {
+			if _, found := newParts[*c.New]; !found {
+				continue
+			}
+		}
+		oldHashes[*c.Old] = true
+	}
+	oldParts, err := old(ctx, slices.Collect(maps.Keys(oldHashes)))
+	if err != nil {
+		return err
+	}
+	side := func(prefix, p string, h *protocol.Hash, parts map[protocol.Hash][]byte) (string, string, bool) {
+		if h == nil {
+			return "/dev/null", "", true
+		}
+		b, found := parts[*h]
+		return prefix + p, string(b), found
+	}
+	for _, i := range selected {
+		c := &changes[i]
+		oldName, oldText, oldOK := side("a/", c.Path, c.Old, oldParts)
+		newName, newText, newOK := side("b/", c.Path, c.New, newParts)
+		if oldOK && newOK {
+			c.Diff = textdiff.Unified(oldName, newName, oldText, newText, 3)
+		}
+	}
+	return nil
+}
+
+// emitChanges reports changes, then their summary
+func emitChanges(emit emitter, changes []Change) {
+	counts := make(map[ChangeKind]int)
+	for _, c := range changes {
+		counts[c.Kind]++
+		emit.emit(Event{Type: EventChange, Path: c.Path, Status: string(c.Kind), Message: c.Diff})
+	}
+	emit.emit(Event{Type: EventCompared, Files: len(changes), Message: fmt.Sprintf("%d added, %d removed, %d modified", counts[Added], counts[Removed], counts[Modified])})
+}
+
+// diffTrees lists the files that differ from old to new, sorted by path
+func diffTrees(old, new *protocol.Node) []Change {
+	var changes []Change
+	diffNodes("", old, new, &changes)
+	// Walking the trees lists "x/y" before "x-y", and the files of a directory replaced by a file before it
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
+
+func diffNodes(p string, old, new *protocol.Node, changes *[]Change) {
+	switch {
+	case old == nil && new == nil:
+	case old == nil:
+		listFiles(p, new, Added, changes)
+	case new == nil:
+		listFiles(p, old, Removed, changes)
+	case old.Hash != nil && new.Hash != nil:
+		if *old.Hash != *new.Hash {
+			*changes = append(*changes, Change{Path: p, Kind: Modified, Old: old.Hash, New: new.Hash})
+		}
+	case old.Hash != nil || new.Hash != nil:
+		// A file replaced a directory, or the reverse
+		listFiles(p, old, Removed, changes)
+		listFiles(p, new, Added, changes)
+	default:
+		names := slices.Collect(maps.Keys(old.Children))
+		for name := range new.Children {
+			if _, found := old.Children[name]; !found {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			diffNodes(path.Join(p, name), old.Children[name], new.Children[name], changes)
+		}
+	}
+}
+
+// listFiles records every file under node as added or removed
+func listFiles(p string, node *protocol.Node, kind ChangeKind, changes *[]Change) {
+	if node.Hash != nil {
+		c := Change{Path: p, Kind: kind}
+		if kind == Added {
+			c.New = node.Hash
+		} else {
+			c.Old = node.Hash
+		}
+		*changes = append(*changes, c)
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(node.Children)) {
+		listFiles(path.Join(p, name), node.Children[name], kind, changes)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/zeebo/blake3"
+)
+
+// tree builds a bundle tree from paths and contents, returning the contents by hash
+func tree(files map[string]string) (*protocol.Node, map[protocol.Hash][]byte) {
+	root := &protocol.Node{}
+	parts := make(map[protocol.Hash][]byte)
+	for p, content := range files {
+		node := root
+		for _, name := range strings.Split(p, "/") {
+			if node.Children == nil {
+				node.Children = make(map[string]*protocol.Node)
+			}
+			if node.Children[name] == nil {
+				node.Children[name] = &protocol.Node{}
+			}
+			node = node.Children[name]
+		}
+		h := protocol.Hash(blake3.Sum256([]byte(content)))
+		node.Hash = &h
+		parts[h] = []byte(content)
+	}
+	return root, parts
+}
+
+func TestDiffTreesSorted(t *testing.T) {
+	old, _ := tree(map[string]string{"x/y": "1", "x/z": "2", "x-y": "3", "a": "4"})
+	new, _ := tree(map[string]string{"x": "5", "x-y": "6", "b/c": "7"})
+	var got []string
+	for _, c := range diffTrees(old, new) {
+		got = append(got, string(c.Kind[0])+" "+c.Path)
+	}
+	want := []string{"r a", "a b/c", "a x", "m x-y", "r x/y", "r x/z"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// loader serves parts like the contents of a diff side, recording the requested hashes
+func loader(parts map[protocol.Hash][]byte, requested *[]protocol.Hash) contents {
+	return func(_ context.Context, hashes []protocol.Hash) (map[protocol.Hash][]byte, error) {
+		*requested = append(*requested, hashes...)
+		loaded := make(map[protocol.Hash][]byte)
+		for _, h := range hashes {
+			if diffable(parts[h]) {
+				loaded[h] = parts[h]
+			}
+		}
+		return loaded, nil
+	}
+}
+
+func TestDiffContents(t *testing.T) {
+	large := strings.Repeat("x\n", maxDiffSize)
+	old, oldParts := tree(map[string]string{"a.txt": "a\nb\n", "big.txt": "small\n", "gone.md": "bye\n", "img.png": "1"})
+	new, newParts := tree(map[string]string{"a.txt": "a\nc\n", "big.txt": large, "img.png": "2"})
+	changes := diffTrees(old, new)
+	var oldRequested, newRequested []protocol.Hash
+	if err := diffContents(context.Background(), changes, loader(oldParts, &oldRequested), loader(newParts, &newRequested)); err != nil {
+		t.Fatal(err)
+	}
+	diffs := make(map[string]string)
+	for _, c := range changes {
+		diffs[c.Path] = c.Diff
+	}
+	if !strings.Contains(diffs["a.txt"], "-b\n+c\n") {
+		t.Errorf("a.txt diff is %q", diffs["a.txt"])
+	}
+	if !strings.Contains(diffs["gone.md"], "+++ /dev/null") {
+		t.Errorf("gone.md diff is %q", diffs["gone.md"])
+	}
+	if diffs["big.txt"] != "" || diffs["img.png"] != "" {
+		t.Errorf("diffed a large or binary file: %q", diffs)
+	}
+	// The old side of big.txt is useless once its new side is known to be too large
+	if len(oldRequested) != 2 {
+		t.Errorf("requested %d old parts, want those of a.txt and gone.md", len(oldRequested))
+	}
+	if len(newRequested) != 2 {
+		t.Errorf("requested %d new parts, want those of a.txt and big.txt", len(newRequested))
+	}
+}
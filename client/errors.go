@@ -0,0 +1,34 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// ErrNoKey is returned when an operation requires an API key and none was given
+var ErrNoKey = errors.New("no key found")
+
+// ServerError reports an operation the server declined
+type ServerError struct {
+	Op       string
+	Response protocol.Response
+}
+
+func (e *ServerError) Error() string {
+	if len(e.Response.Errors) == 0 {
+		return e.Op + " failed"
+	}
+	return fmt.Sprintf("%s failed: %s", e.Op, strings.Join(e.Response.Errors, "; "))
+}
+
+// check forwards the messages of a response and turns its failure into a ServerError
+func (e emitter) check(op string, resp protocol.Response) error {
+	e.messages(resp)
+	if !resp.Success {
+		return &ServerError{Op: op, Response: resp}
+	}
+	return nil
+}
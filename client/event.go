@@ -0,0 +1,83 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// Event types, in the order they typically occur
+const (
+	EventDiscovering = "discovering" // looking up the endpoint
+	EventDiscovery   = "discovery"   // URL: endpoint found
+	EventBundling    = "bundling"    // Path: directory being ingested
+	EventSkipped     = "skipped"     // Path: file or directory excluded from the bundle
+	EventBundle      = "bundle"      // Bundle; for deploys, Files, Parts and Bytes too
+	EventMessage     = "message"     // Level, Message: server error, warning or information
+	EventWarning     = "warning"     // Message: non-fatal local problem
+	EventMissing     = "missing"     // Parts, Bytes: parts the server lacks
+	EventChunk       = "chunk"       // Status, Chunk, Chunks, Parts, Bytes: chunk upload progress
+	EventPending     = "pending"     // Path, Bytes: file a dry run would upload or download
+	EventExtraneous  = "extraneous"  // Path: destination entry a dry run would delete
+	EventDryRun      = "dry-run"     // Files, Parts, Bytes; Status "new" if the server did not know the bundle, "download" for downloads
+	EventPromoting   = "promoting"   // ID, Bundle: earlier upload being made live again
+	EventFinalized   = "finalized"   // Bundle: the deployed bundle is live
+	EventDownloading = "downloading" // Path: file being downloaded
+	EventFile        = "file"        // Path, Bytes: file downloaded
+	EventDeleted     = "deleted"     // Path: extraneous destination entry removed once the new files are in place
+	EventDownloaded  = "downloaded"  // Bundle, Files, Bytes: fetch complete
+	EventApproval    = "approval"    // URL: page where a key request awaits approval
+	EventChange      = "change"      // Path, Status: file added, removed or modified between two trees
+	EventCompared    = "compared"    // Files, Message: comparison complete, with its summary
+	EventError       = "error"       // Message: the operation failed
+)
+
+// Message levels
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelInfo    = "info"
+)
+
+// Event is a record of deploy or fetch progress, suitable for JSON encoding
+type Event struct {
+	Type    string `json:"type"`
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Path    string `json:"path,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Bundle  string `json:"bundle,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	Parts   int    `json:"parts,omitempty"`
+	Chunk   int    `json:"chunk,omitempty"`
+	Chunks  int    `json:"chunks,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+}
+
+// emitter delivers events to an optional callback
+type emitter func(Event)
+
+func (e emitter) emit(ev Event) {
+	if e != nil {
+		e(ev)
+	}
+}
+
+func (e emitter) warn(format string, args ...any) {
+	e.emit(Event{Type: EventWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// messages forwards the errors, warnings and messages of a response as events
+func (e emitter) messages(resp protocol.Response) {
+	for _, err := range resp.Errors {
+		e.emit(Event{Type: EventMessage, Level: LevelError, Message: err})
+	}
+	for _, warn := range resp.Warnings {
+		e.emit(Event{Type: EventMessage, Level: LevelWarning, Message: warn})
+	}
+	for _, message := range resp.Messages {
+		e.emit(Event{Type: EventMessage, Level: LevelInfo, Message: message})
+	}
+}
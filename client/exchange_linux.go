@@ -0,0 +1,39 @@
+package client
+
+import (
+	"errors"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchange atomically swaps two directories, falling back to renames on filesystems that cannot
+func exchange(staging, destination string) (string, error) {
+	err := unix.Renameat2(unix.AT_FDCWD, staging, unix.AT_FDCWD, destination, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		return renameAside(staging, destination)
+	}
+	if err != nil {
+		return "", err
+	}
+	return staging, nil
+}
+
+// unrenamable tells why directory cannot be renamed, or returns "" if it can: it must be no mount point
+// and its parent must be writable
+func unrenamable(directory string) string {
+	var st, parent unix.Stat_t
+	if err := unix.Stat(directory, &st); err != nil {
+		return err.Error()
+	}
+	if err := unix.Stat(filepath.Dir(directory), &parent); err != nil {
+		return err.Error()
+	}
+	if st.Dev != parent.Dev {
+		return "it is a mount point"
+	}
+	if unix.Access(filepath.Dir(directory), unix.W_OK) != nil {
+		return "its parent directory is not writable"
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package client
+
+// exchange swaps two directories; without an atomic exchange, destination briefly disappears
+func exchange(staging, destination string) (string, error) {
+	return renameAside(staging, destination)
+}
+
+// unrenamable tells why directory cannot be renamed; without a portable way to tell, it assumes it can
+func unrenamable(string) string {
+	return ""
+}
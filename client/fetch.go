@@ -0,0 +1,512 @@
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/zeebo/blake3"
+)
+
+// FetchOptions configures Fetch
+type FetchOptions struct {
+	Connection
+
+	Domain      string
+	ID          string // upload ID, latest if empty
+	Destination string
+	Team        string // team to act for
+
+	// Delete removes the files and directories of the destination missing from the upload.
+	// Unless Force is set, a non-empty destination must come from an earlier download.
+	Delete bool
+	Force  bool
+	DryRun bool // only report what would be downloaded and deleted
+
+	// InPlace allows updating a destination that cannot be swapped atomically (the filesystem root,
+	// one containing the working directory, a mount point or one under an unwritable directory)
+	// by moving its entries one at a time, so it is seen half-updated meanwhile
+	InPlace bool
+
+	Parallelism int // concurrent part downloads (default: 3)
+}
+
+// FetchResult describes a fetched bundle
+type FetchResult struct {
+	Bundle  protocol.Hash
+	Files   int      // files written, or that a dry run would write
+	Bytes   int64    // bytes written
+	Deleted []string // paths removed (or that a dry run would remove) by Delete, relative to the destination
+}
+
+// MarkerFile is written at the root of every download destination, identifying it as such
+const MarkerFile = ".xmit-download"
+
+const (
+	// bytesPerRequest is the size of part downloads batches aim for, as for uploads
+	bytesPerRequest = 10 * 1024 * 1024
+	// partsPerRequest bounds the parts requested at once, however small
+	partsPerRequest = 100
+	// firstBatchParts is the size of the first batches, before any part size is known
+	firstBatchParts = 10
+)
+
+// batcher hands out batches of parts to download. Bundles do not record sizes, so batches are
+// sized from the parts received so far: a response exceeding bytesPerRequest shrinks the next ones.
+type batcher struct {
+	mu     sync.Mutex
+	hashes []protocol.Hash
+	parts  int // parts in the next batch
+}
+
+func newBatcher(hashes []protocol.Hash) *batcher {
+	return &batcher{hashes: hashes, parts: firstBatchParts}
+}
+
+// next returns the next batch, empty once every part was handed out
+func (b *batcher) next() []protocol.Hash {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := min(b.parts, len(b.hashes))
+	batch := b.hashes[:n:n]
+	b.hashes = b.hashes[n:]
+	return batch
+}
+
+// received sizes the next batches from the parts of a response
+func (b *batcher) received(parts map[protocol.Hash][]byte) {
+	var size int64
+	for _, p := range parts {
+		size += int64(len(p))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size == 0 {
+		b.parts = partsPerRequest
+		return
+	}
+	b.parts = int(min(max(int64(len(parts))*bytesPerRequest/size, 1), partsPerRequest))
+}
+
+// fetcher holds the state of a download
+type fetcher struct {
+	downloader *protocol.ParallelDownloader
+	emit       emitter
+	req        protocol.Request
+	batches    *batcher
+	files      atomic.Int64
+	bytes      atomic.Int64
+
+	destination string
+	staging     string // directory mirroring destination where files are written
+}
+
+// staged returns where to write a path of the destination
+func (f *fetcher) staged(p string) (string, error) {
+	rel, err := filepath.Rel(f.destination, p)
+	if err != nil {
+		return "", fmt.Errorf("staging %s: %w", p, err)
+	}
+	return filepath.Join(f.staging, rel), nil
+}
+
+// Fetch downloads an upload of opts.Domain into opts.Destination
+func Fetch(ctx context.Context, opts FetchOptions) (*FetchResult, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+
+	// Settle how the destination will be replaced before downloading anything
+	target, pinned, err := resolveDestination(opts.Destination)
+	if err != nil {
+		return nil, err
+	}
+	inPlace := pinned != ""
+	if inPlace {
+		if !opts.InPlace {
+			return nil, fmt.Errorf("%s cannot be replaced atomically as %s: download elsewhere, or allow updating it in place with --in-place", opts.Destination, pinned)
+		}
+		emit.warn("%s cannot be replaced atomically as %s, updating it in place", opts.Destination, pinned)
+	}
+
+	if opts.Delete && !opts.Force {
+		if err := checkMirror(opts.Destination); err != nil {
+			return nil, err
+		}
+	}
+
+	req := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}
+
+	downloader, err := newDownloader(ctx, opts.Connection, cmp.Or(opts.Parallelism, 3), emit)
+	if err != nil {
+		return nil, err
+	}
+
+	node, hash, err := downloadTree(ctx, downloader.Client, req, opts.ID, emit)
+	if err != nil {
+		return nil, err
+	}
+	result := &FetchResult{Bundle: hash}
+	bundle := fmt.Sprintf("%x", result.Bundle)
+
+	if opts.Delete {
+		if err := extraneous(node, opts.Destination, "", &result.Deleted); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DryRun {
+		for _, p := range result.Deleted {
+			emit.emit(Event{Type: EventExtraneous, Path: p})
+		}
+		var jobs []fileJob
+		if err := plan(node, opts.Destination, "", &jobs); err != nil {
+			return nil, err
+		}
+		pending, hashes, _, err := stale(ctx, jobs)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hashes {
+			for _, p := range pending[h] {
+				result.Files++
+				emit.emit(Event{Type: EventPending, Path: p})
+			}
+		}
+		emit.emit(Event{Type: EventDryRun, Status: "download", Files: result.Files, Parts: len(hashes)})
+		return result, nil
+	}
+
+	// Keep what the upload lacks, unless it is in the way or deletion was requested
+	var extra []string
+	if !opts.Delete {
+		if err := extraneous(node, opts.Destination, "", &extra); err != nil {
+			return nil, err
+		}
+		for _, p := range extra {
+			if lookup(node, p) != nil {
+				return nil, fmt.Errorf("%s conflicts with the upload, delete it or mirror the upload", filepath.Join(opts.Destination, filepath.FromSlash(p)))
+			}
+		}
+	}
+
+	// Build the new tree next to the destination (or within it, when it cannot be renamed), reusing
+	// its unchanged files, and only swap it in once complete so the destination is never seen half-updated.
+	// Leftovers of interrupted in-place updates are not carried over, and go with the previous files
+	staging, err := newStaging(target, inPlace)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	f := &fetcher{
+		downloader:  downloader,
+		emit:        emit,
+		req:         req,
+		destination: opts.Destination,
+		staging:     staging,
+	}
+
+	var jobs []fileJob
+	if err := plan(node, opts.Destination, staging, &jobs); err != nil {
+		return nil, err
+	}
+	pending, hashes, unchanged, err := stale(ctx, jobs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range unchanged {
+		staged, err := f.staged(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := linkFile(p, staged, 0644); err != nil {
+			return nil, fmt.Errorf("staging %s: %w", p, err)
+		}
+	}
+	for _, p := range extra {
+		if err := carry(filepath.Join(opts.Destination, filepath.FromSlash(p)), filepath.Join(staging, filepath.FromSlash(p))); err != nil {
+			return nil, err
+		}
+	}
+
+	f.batches = newBatcher(hashes)
+	if err := f.fetchAll(ctx, cmp.Or(opts.Parallelism, 3), func(ctx context.Context, batch []protocol.Hash) error {
+		return f.download(ctx, batch, pending)
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeMarker(staging, opts.Domain, bundle); err != nil {
+		return nil, err
+	}
+	old, err := swap(staging, target, inPlace)
+	if err != nil {
+		return nil, fmt.Errorf("replacing %s: %w", opts.Destination, err)
+	}
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			emit.warn("failed to remove previous files in %s: %v", old, err)
+		}
+	}
+	for _, p := range result.Deleted {
+		emit.emit(Event{Type: EventDeleted, Path: p})
+	}
+	result.Files = int(f.files.Load())
+	result.Bytes = f.bytes.Load()
+	emit.emit(Event{Type: EventDownloaded, Bundle: bundle, Files: result.Files, Bytes: result.Bytes})
+	return result, nil
+}
+
+// newDownloader discovers the endpoint and creates a parallel downloader for it
+func newDownloader(ctx context.Context, conn Connection, parallelism int, emit emitter) (*protocol.ParallelDownloader, error) {
+	policy := conn.callPolicy()
+	emit.emit(Event{Type: EventDiscovering})
+	discovery, err := protocol.Discover(ctx, conn.URL, policy)
+	if err != nil {
+		return nil, fmt.Errorf("discovering endpoint: %w", err)
+	}
+	emit.emit(Event{Type: EventDiscovery, URL: discovery.URL})
+
+	downloader, err := protocol.NewParallelDownloader(ctx, discovery.URL, parallelism)
+	if err != nil {
+		return nil, fmt.Errorf("creating parallel downloader: %w", err)
+	}
+	downloader.CallPolicy = policy
+	return downloader, nil
+}
+
+// downloadTree downloads and decodes the bundle of an upload (the live one if id is empty)
+func downloadTree(ctx context.Context, c *protocol.Client, req protocol.Request, id string, emit emitter) (*protocol.Node, protocol.Hash, error) {
+	resp, err := c.DownloadBundle(ctx, req, id)
+	if err != nil {
+		return nil, protocol.Hash{}, fmt.Errorf("downloading bundle: %w", err)
+	}
+	if err := emit.check("bundle download", resp.Response); err != nil {
+		return nil, protocol.Hash{}, err
+	}
+	var node protocol.Node
+	if err := cbor.NewDecoder(bytes.NewReader(resp.Bundle)).Decode(&node); err != nil {
+		return nil, protocol.Hash{}, fmt.Errorf("unmarshaling bundle: %w", err)
+	}
+	hash := protocol.Hash(blake3.Sum256(resp.Bundle))
+	emit.emit(Event{Type: EventBundle, Bundle: fmt.Sprintf("%x", hash)})
+	return &node, hash, nil
+}
+
+// safePath ensures the resulting path stays within the base directory
+func safePath(base, name string) (string, error) {
+	joined := filepath.Join(base, name)
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute base path: %w", err)
+	}
+	absJoined, err := filepath.Abs(joined)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute joined path: %w", err)
+	}
+	// Ensure the joined path is within the base directory
+	if !strings.HasPrefix(absJoined, absBase+string(filepath.Separator)) && absJoined != absBase {
+		return "", fmt.Errorf("path traversal detected: %s escapes %s", name, base)
+	}
+	return joined, nil
+}
+
+// checkMirror refuses to mirror into a directory holding more than leftovers that no download created
+func checkMirror(destination string) error {
+	entries, err := os.ReadDir(destination)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(entries, func(entry os.DirEntry) bool { return !leftover(entry.Name()) }) {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(destination, MarkerFile)); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s is not empty and does not come from a download, refusing to delete its files without force", destination)
+}
+
+// writeMarker records which bundle a destination was downloaded from
+func writeMarker(destination, domain, bundle string) error {
+	b, err := json.Marshal(struct {
+		Domain string `json:"domain"`
+		Bundle string `json:"bundle"`
+	}{domain, bundle})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(destination, MarkerFile), append(b, '\n'), 0644)
+}
+
+// extraneous lists, as slash-separated paths relative to the root, the outermost entries
+// of directory that are missing from the tree or whose type differs, besides the marker
+// and leftovers of interrupted in-place updates
+func extraneous(node *protocol.Node, directory, rel string, paths *[]string) error {
+	entries, err := os.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		r := path.Join(rel, entry.Name())
+		if r == MarkerFile || leftover(r) {
+			continue
+		}
+		child, found := node.Children[entry.Name()]
+		isDir := entry.IsDir()
+		switch {
+		case !found || (child.Hash != nil) == isDir:
+			*paths = append(*paths, r)
+		case isDir:
+			if err := extraneous(child, filepath.Join(directory, entry.Name()), r, paths); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// plan lists the files of the tree under destination, creating its directories under staging unless empty
+func plan(node *protocol.Node, destination, staging string, jobs *[]fileJob) error {
+	if node.Hash != nil {
+		*jobs = append(*jobs, fileJob{path: destination, node: node})
+		return nil
+	}
+	if staging != "" {
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(node.Children)) {
+		childPath, err := safePath(destination, name)
+		if err != nil {
+			return err
+		}
+		childStaging := ""
+		if staging != "" {
+			childStaging = filepath.Join(staging, name)
+		}
+		if err := plan(node.Children[name], childPath, childStaging, jobs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stale checks which files are missing or differ from the tree, returning the paths
+// awaiting each part, the parts to download without duplicates, and the files already current
+func stale(ctx context.Context, jobs []fileJob) (map[protocol.Hash][]string, []protocol.Hash, []string, error) {
+	current := make([]bool, len(jobs))
+	if err := parallelize(ctx, len(jobs), runtime.GOMAXPROCS(0), func(_ context.Context, i int) error {
+		hash, _, err := hashFile(jobs[i].path)
+		current[i] = err == nil && hash == *jobs[i].node.Hash
+		return nil
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	pending := make(map[protocol.Hash][]string)
+	var hashes []protocol.Hash
+	var unchanged []string
+	for i, job := range jobs {
+		if current[i] {
+			unchanged = append(unchanged, job.path)
+			continue
+		}
+		h := *job.node.Hash
+		if _, found := pending[h]; !found {
+			hashes = append(hashes, h)
+		}
+		pending[h] = append(pending[h], job.path)
+	}
+	return pending, hashes, unchanged, nil
+}
+
+// lookup returns the node at a slash-separated path of the tree, or nil
+func lookup(node *protocol.Node, p string) *protocol.Node {
+	for _, name := range strings.Split(p, "/") {
+		if node == nil {
+			return nil
+		}
+		node = node.Children[name]
+	}
+	return node
+}
+
+// fetchAll hands the batches of f.batches to fn, from parallelism workers
+func (f *fetcher) fetchAll(ctx context.Context, parallelism int, fn func(ctx context.Context, batch []protocol.Hash) error) error {
+	return parallelize(ctx, parallelism, parallelism, func(ctx context.Context, _ int) error {
+		for batch := f.batches.next(); len(batch) > 0; batch = f.batches.next() {
+			if err := fn(ctx, batch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fetch downloads a batch of parts
+func (f *fetcher) fetch(ctx context.Context, hashes []protocol.Hash) (map[protocol.Hash][]byte, error) {
+	resp, err := f.downloader.DownloadParts(ctx, f.req, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("downloading parts: %w", err)
+	}
+	if err := f.emit.check("part download", resp.Response); err != nil {
+		return nil, err
+	}
+	if len(resp.Parts) != len(hashes) {
+		return nil, fmt.Errorf("requested %d parts, received %d", len(hashes), len(resp.Parts))
+	}
+	parts := make(map[protocol.Hash][]byte, len(hashes))
+	for i, h := range hashes {
+		parts[h] = resp.Parts[i]
+	}
+	f.batches.received(parts)
+	return parts, nil
+}
+
+// download fetches a batch of parts and writes each to the staged copy of every path awaiting it
+func (f *fetcher) download(ctx context.Context, hashes []protocol.Hash, pending map[protocol.Hash][]string) error {
+	for _, h := range hashes {
+		for _, p := range pending[h] {
+			f.emit.emit(Event{Type: EventDownloading, Path: p})
+		}
+	}
+	parts, err := f.fetch(ctx, hashes)
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		content := parts[h]
+		for _, p := range pending[h] {
+			staged, err := f.staged(p)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(staged, content, 0644); err != nil {
+				return fmt.Errorf("writing file: %w", err)
+			}
+			f.files.Add(1)
+			f.bytes.Add(int64(len(content)))
+			f.emit.emit(Event{Type: EventFile, Path: p, Bytes: int64(len(content))})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// fakeDownloads serves the upload of files
+func fakeDownloads(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	root, parts := tree(files)
+	bundle, err := cbor.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/.well-known/web-publication-protocol", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(protocol.DiscoveryInfo{Protocols: []string{"xmit/0"}, URL: srv.URL})
+	})
+	mux.HandleFunc("/api/0/dl/bundle", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.BundleDownloadRequest
+		decodeBody(t, r, &req)
+		encodeBody(t, w, protocol.BundleDownloadResponse{Response: protocol.Response{Success: true}, Bundle: bundle})
+	})
+	mux.HandleFunc("/api/0/dl/parts", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.PartsDownloadRequest
+		decodeBody(t, r, &req)
+		resp := protocol.PartsDownloadResponse{Response: protocol.Response{Success: true}}
+		for _, h := range req.Hashes {
+			resp.Parts = append(resp.Parts, parts[h])
+		}
+		encodeBody(t, w, resp)
+	})
+	return srv
+}
+
+// fetch downloads the upload of srv into destination with the options of opts
+func fetch(t *testing.T, srv *httptest.Server, destination string, opts FetchOptions) (*FetchResult, []Event, error) {
+	t.Helper()
+	var events []Event
+	opts.Connection = Connection{URL: srv.URL, Key: "key", OnEvent: func(e Event) { events = append(events, e) }}
+	opts.Domain = "example.com"
+	opts.Destination = destination
+	result, err := Fetch(context.Background(), opts)
+	return result, events, err
+}
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for p, content := range files {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree lists the files and directories under dir, with the contents of files
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			files[rel+"/"] = ""
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		files[rel] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(files, MarkerFile)
+	return files
+}
+
+// eventPaths lists the paths of the events of a type
+func eventPaths(events []Event, typ string) []string {
+	var paths []string
+	for _, e := range events {
+		if e.Type == typ {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths
+}
+
+func expectTree(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	if got := readTree(t, dir); !maps.Equal(got, want) {
+		t.Errorf("destination holds %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want)))
+	}
+}
+
+var upload = map[string]string{"index.html": "home", "a/x.txt": "x"}
+
+// uploadTree is how upload looks on disk
+var uploadTree = map[string]string{"index.html": "home", "a/": "", "a/x.txt": "x"}
+
+func TestFetchDeleteRefusesUnmarkedDestination(t *testing.T) {
+	srv := fakeDownloads(t, upload)
+	dest := t.TempDir()
+	writeTree(t, dest, map[string]string{"mine.txt": "precious"})
+
+	_, _, err := fetch(t, srv, dest, FetchOptions{Delete: true})
+	if err == nil || !strings.Contains(err.Error(), "does not come from a download") {
+		t.Fatalf("got %v, want a refusal", err)
+	}
+	expectTree(t, dest, map[string]string{"mine.txt": "precious"})
+
+	if _, _, err := fetch(t, srv, dest, FetchOptions{Delete: true, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	expectTree(t, dest, uploadTree)
+
+	// The marker left by the download allows mirroring again without force
+	writeTree(t, dest, map[string]string{"stray.txt": ""})
+	if _, _, err := fetch(t, srv, dest, FetchOptions{Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	expectTree(t, dest, uploadTree)
+}
+
+func TestFetchDeletePrunes(t *testing.T) {
+	srv := fakeDownloads(t, upload)
+	dest := filepath.Join(t.TempDir(), "site")
+	if _, _, err := fetch(t, srv, dest, FetchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dest, map[string]string{
+		"a/b/c/d.txt":    "nested under a kept directory",
+		"old/deep/e.txt": "nested under a removed directory",
+		"stray.txt":      "",
+	})
+	if err := os.Mkdir(filepath.Join(dest, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	before := readTree(t, dest)
+
+	result, events, err := fetch(t, srv, dest, FetchOptions{Delete: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDeleted := []string{"a/b", "empty", "old", "stray.txt"}
+	if !slices.Equal(result.Deleted, wantDeleted) || !slices.Equal(eventPaths(events, EventExtraneous), wantDeleted) {
+		t.Errorf("dry run would delete %q (events %q), want %q", result.Deleted, eventPaths(events, EventExtraneous), wantDeleted)
+	}
+	if deleted := eventPaths(events, EventDeleted); len(deleted) > 0 {
+		t.Errorf("dry run reported deleting %q", deleted)
+	}
+	expectTree(t, dest, before)
+
+	result, events, err = fetch(t, srv, dest, FetchOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Deleted, wantDeleted) || !slices.Equal(eventPaths(events, EventDeleted), wantDeleted) {
+		t.Errorf("deleted %q (events %q), want %q", result.Deleted, eventPaths(events, EventDeleted), wantDeleted)
+	}
+	if events[len(events)-1].Type != EventDownloaded {
+		t.Errorf("last event is %q, want deletions reported before completion", events[len(events)-1].Type)
+	}
+	expectTree(t, dest, uploadTree)
+}
+
+func TestFetchKeepsExtras(t *testing.T) {
+	srv := fakeDownloads(t, upload)
+	dest := t.TempDir()
+	writeTree(t, dest, map[string]string{
+		"index.html":  "outdated",
+		"a/mine.txt":  "mine",
+		"b/c/d.txt":   "deep",
+		"notes.txt":   "notes",
+		"a/x.txt.bak": "backup",
+	})
+
+	result, events, err := fetch(t, srv, dest, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Deleted) > 0 || len(eventPaths(events, EventDeleted)) > 0 {
+		t.Errorf("deleted %q without --delete", result.Deleted)
+	}
+	expectTree(t, dest, map[string]string{
+		"index.html":  "home",
+		"a/":          "",
+		"a/x.txt":     "x",
+		"a/mine.txt":  "mine",
+		"a/x.txt.bak": "backup",
+		"b/":          "",
+		"b/c/":        "",
+		"b/c/d.txt":   "deep",
+		"notes.txt":   "notes",
+	})
+
+	// An extra file where the upload has a directory is in the way
+	conflict := t.TempDir()
+	writeTree(t, conflict, map[string]string{"a": "file"})
+	if _, _, err := fetch(t, srv, conflict, FetchOptions{}); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("got %v, want a conflict", err)
+	}
+	expectTree(t, conflict, map[string]string{"a": "file"})
+}
+
+func TestFetchInPlace(t *testing.T) {
+	srv := fakeDownloads(t, upload)
+	dest := t.TempDir()
+	leftovers := map[string]string{stagingPrefix + "1/index.html": "partial", oldPrefix + "2/a/x.txt": "previous"}
+	writeTree(t, dest, leftovers)
+	t.Chdir(dest)
+
+	_, _, err := fetch(t, srv, ".", FetchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "contains the working directory") {
+		t.Fatalf("got %v, want a refusal", err)
+	}
+
+	// Leftovers are neither extraneous nor carried over
+	result, _, err := fetch(t, srv, ".", FetchOptions{Delete: true, DryRun: true, InPlace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Deleted) > 0 {
+		t.Errorf("dry run would delete %q", result.Deleted)
+	}
+	_, events, err := fetch(t, srv, ".", FetchOptions{InPlace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eventPaths(events, EventWarning)) != 1 {
+		t.Errorf("got events %v, want a warning about updating in place", events)
+	}
+	expectTree(t, dest, uploadTree)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// HistoryOptions configures History
+type HistoryOptions struct {
+	Connection
+
+	Domain string
+	Team   string // team to act for
+	Limit  int    // most recent uploads to list, 0 for the server's default
+}
+
+// History lists the past uploads of opts.Domain, most recent first
+func History(ctx context.Context, opts HistoryOptions) ([]protocol.Upload, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	c, err := connect(ctx, opts.Connection, emit)
+	if err != nil {
+		return nil, err
+	}
+	return listUploads(ctx, c, protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}, opts.Limit, emit)
+}
+
+// listUploads lists up to limit uploads of the domain of req (0 for the server's default)
+func listUploads(ctx context.Context, c *protocol.Client, req protocol.Request, limit int, emit emitter) ([]protocol.Upload, error) {
+	resp, err := c.ListUploads(ctx, req, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+	if err := emit.check("upload listing", resp.Response); err != nil {
+		return nil, err
+	}
+	return resp.Uploads, nil
+}
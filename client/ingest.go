@@ -0,0 +1,233 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/xmit-co/xmit/config"
+	"github.com/xmit-co/xmit/ignore"
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/zeebo/blake3"
+)
+
+// part is a unique file content, identified by its hash, and the paths it was found at
+type part struct {
+	size  int64
+	paths []string
+}
+
+type ingestion struct {
+	protocol.Node
+	parts map[protocol.Hash]*part
+}
+
+// fileJob is a file found during traversal whose node awaits its hash
+type fileJob struct {
+	path string
+	node *protocol.Node
+}
+
+// ingest bundles directory; cache is optional and lets unchanged files skip hashing
+func ingest(ctx context.Context, directory string, parallelism int, cache *hashCache, emit emitter) (*ingestion, error) {
+	bundle := ingestion{
+		parts: make(map[protocol.Hash]*part),
+	}
+	cfg, err := config.Load(directory)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []fileJob
+	if err := traverse(directory, "", ignore.New(cfg.Ignore), &bundle.Node, &jobs, emit); err != nil {
+		return nil, err
+	}
+	if err := bundle.hashFiles(ctx, jobs, parallelism, cache); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.prune(directory)
+	}
+	return &bundle, nil
+}
+
+// traverse walks directory, whose slash-separated path relative to the root is rel,
+// building the tree and collecting the files to hash
+func traverse(directory, rel string, matcher *ignore.Matcher, node *protocol.Node, jobs *[]fileJob, emit emitter) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	matcher, err = matcher.WithFile(directory, rel)
+	if err != nil {
+		return err
+	}
+	if node.Children == nil {
+		node.Children = make(map[string]*protocol.Node)
+	}
+	for _, entry := range entries {
+		p := filepath.Join(directory, entry.Name())
+		r := path.Join(rel, entry.Name())
+		if (entry.Name() == ignore.FileName || r == MarkerFile) && !entry.IsDir() {
+			continue
+		}
+		if (entry.IsDir() && entry.Name() == ".git") || matcher.Ignored(r, entry.IsDir()) {
+			emit.emit(Event{Type: EventSkipped, Path: p})
+			continue
+		}
+		if entry.IsDir() {
+			child := protocol.Node{}
+			err := traverse(p, r, matcher, &child, jobs, emit)
+			if err != nil {
+				return err
+			}
+			node.Children[entry.Name()] = &child
+		} else {
+			child := &protocol.Node{}
+			node.Children[entry.Name()] = child
+			*jobs = append(*jobs, fileJob{path: p, node: child})
+		}
+	}
+	return nil
+}
+
+// hashFiles reads and hashes files using a bounded pool of workers.
+// Each job fills in its own node, so the resulting tree does not depend on scheduling.
+func (b *ingestion) hashFiles(ctx context.Context, jobs []fileJob, parallelism int, cache *hashCache) error {
+	parallelism = max(1, min(parallelism, len(jobs)))
+	queue := make(chan fileJob)
+	done := make(chan struct{})
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				hash, size, err := hashCachedFile(job.path, cache)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						close(done)
+					}
+				} else {
+					job.node.Hash = &hash
+					if existing, ok := b.parts[hash]; ok {
+						existing.paths = append(existing.paths, job.path)
+					} else {
+						b.parts[hash] = &part{size: size, paths: []string{job.path}}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-done:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, pt := range b.parts {
+		slices.Sort(pt.paths)
+	}
+	return nil
+}
+
+// hashCachedFile hashes a file unless cache knows it under the same size, mtime and inode
+func hashCachedFile(p string, cache *hashCache) (protocol.Hash, int64, error) {
+	if cache == nil {
+		return hashFile(p)
+	}
+	before, err := os.Stat(p)
+	if err != nil {
+		return protocol.Hash{}, 0, err
+	}
+	if hash, ok := cache.lookup(p, before); ok {
+		return hash, before.Size(), nil
+	}
+	hash, size, err := hashFile(p)
+	if err != nil {
+		return hash, size, err
+	}
+	if after, err := os.Stat(p); err == nil && after.Size() == size &&
+		after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+		cache.store(p, after, hash)
+	}
+	return hash, size, nil
+}
+
+// hashFile streams a file through BLAKE3 without holding it in memory
+func hashFile(p string) (protocol.Hash, int64, error) {
+	var hash protocol.Hash
+	f, err := os.Open(p)
+	if err != nil {
+		return hash, 0, err
+	}
+	defer f.Close()
+	h := blake3.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return hash, 0, err
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, size, nil
+}
+
+// textContents loads the parts that can be diffed from the files they were found in,
+// skipping those too large to diff without reading them
+func (b *ingestion) textContents(_ context.Context, hashes []protocol.Hash) (map[protocol.Hash][]byte, error) {
+	parts := make(map[protocol.Hash][]byte, len(hashes))
+	for _, h := range hashes {
+		if pt, found := b.parts[h]; found && pt.size > maxDiffSize {
+			continue
+		}
+		content, err := b.load(h)
+		if err != nil {
+			return nil, err
+		}
+		if diffable(content) {
+			parts[h] = content
+		}
+	}
+	return parts, nil
+}
+
+// load reads a part back from disk, checking it did not change since it was ingested
+func (b *ingestion) load(hash protocol.Hash) ([]byte, error) {
+	pt, ok := b.parts[hash]
+	if !ok {
+		return nil, fmt.Errorf("unknown part %x", hash)
+	}
+	p := pt.paths[0]
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	h := protocol.Hash(blake3.Sum256(content))
+	if !bytes.Equal(h[:], hash[:]) {
+		return nil, fmt.Errorf("%s changed since it was bundled", p)
+	}
+	return content, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package client
+
+import "os"
+
+func inode(os.FileInfo) uint64 {
+	return 0
+}
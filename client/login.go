@@ -0,0 +1,73 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// LoginOptions configures Login
+type LoginOptions struct {
+	Connection
+
+	Name         string        // label of the requested key, shown when approving it
+	PollInterval time.Duration // delay between approval checks (default: 2s)
+}
+
+// Login requests a key, which the user approves in a browser at the URL
+// of the EventApproval event, and waits for the approval to return the key
+func Login(ctx context.Context, opts LoginOptions) (string, error) {
+	emit := emitter(opts.OnEvent)
+	policy := opts.callPolicy()
+
+	emit.emit(Event{Type: EventDiscovering})
+	discovery, err := protocol.Discover(ctx, opts.URL, policy)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover endpoint: %w", err)
+	}
+	emit.emit(Event{Type: EventDiscovery, URL: discovery.URL})
+
+	resp, err := protocol.RequestKey(ctx, discovery.URL, opts.Name, policy)
+	if err != nil {
+		return "", fmt.Errorf("failed to request key: %w", err)
+	}
+	if err := emit.check("key request", resp.Response); err != nil {
+		return "", err
+	}
+	emit.emit(Event{Type: EventApproval, URL: resp.BrowserURL})
+
+	ticker := time.NewTicker(cmp.Or(opts.PollInterval, 2*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+		poll, err := protocol.PollKey(ctx, discovery.URL, resp.PollURL, protocol.PollKeyRequest{
+			RequestID: resp.RequestID,
+			Secret:    resp.Secret,
+		}, policy)
+		if err != nil {
+			if protocol.Temporary(err) && ctx.Err() == nil {
+				emit.warn("checking key approval: %v", err)
+				continue
+			}
+			return "", fmt.Errorf("failed to check key approval: %w", err)
+		}
+		if poll.Success && poll.Pending && poll.Key == "" {
+			continue
+		}
+		if err := emit.check("key approval", poll.Response); err != nil {
+			return "", err
+		}
+		if poll.Key == "" {
+			return "", errors.New("key approval returned no key")
+		}
+		return poll.Key, nil
+	}
+}
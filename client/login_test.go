@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// fakeLogin serves discovery, key requests and polls answered by poll, counting polls
+func fakeLogin(t *testing.T, poll func(n int) protocol.PollKeyResponse) *httptest.Server {
+	t.Helper()
+	var polls atomic.Int64
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/.well-known/web-publication-protocol", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(protocol.DiscoveryInfo{Protocols: []string{"xmit/0"}, URL: srv.URL})
+	})
+	mux.HandleFunc("/api/0/key", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.RequestKeyRequest
+		decodeBody(t, r, &req)
+		if req.Name != "test" {
+			t.Errorf("key requested with name %q", req.Name)
+		}
+		encodeBody(t, w, protocol.RequestKeyResponse{
+			Response:   protocol.Response{Success: true},
+			BrowserURL: srv.URL + "/approve",
+			PollURL:    "/poll",
+			Secret:     "secret",
+			RequestID:  "42",
+		})
+	})
+	mux.HandleFunc("/poll", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.PollKeyRequest
+		decodeBody(t, r, &req)
+		if req.RequestID != "42" || req.Secret != "secret" {
+			t.Errorf("polled with %+v", req)
+		}
+		encodeBody(t, w, poll(int(polls.Add(1))))
+	})
+	return srv
+}
+
+func decodeBody(t *testing.T, r *http.Request, v any) {
+	t.Helper()
+	zr, err := zstd.NewReader(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if err := cbor.NewDecoder(zr).Decode(v); err != nil {
+		t.Errorf("decoding request: %v", err)
+	}
+}
+
+func encodeBody(t *testing.T, w http.ResponseWriter, v any) {
+	t.Helper()
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cbor.NewEncoder(zw).Encode(v); err != nil {
+		t.Error(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func login(t *testing.T, srv *httptest.Server, timeout time.Duration) (string, []Event, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var events []Event
+	key, err := Login(ctx, LoginOptions{
+		Connection: Connection{
+			URL:     srv.URL,
+			OnEvent: func(e Event) { events = append(events, e) },
+		},
+		Name:         "test",
+		PollInterval: time.Millisecond,
+	})
+	return key, events, err
+}
+
+func TestLoginApproved(t *testing.T) {
+	srv := fakeLogin(t, func(n int) protocol.PollKeyResponse {
+		if n < 3 {
+			return protocol.PollKeyResponse{Response: protocol.Response{Success: true}, Pending: true}
+		}
+		return protocol.PollKeyResponse{Response: protocol.Response{Success: true}, Key: "the-key"}
+	})
+	key, events, err := login(t, srv, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "the-key" {
+		t.Errorf("got key %q", key)
+	}
+	approval := false
+	for _, e := range events {
+		if e.Type == EventApproval && e.URL == srv.URL+"/approve" {
+			approval = true
+		}
+	}
+	if !approval {
+		t.Errorf("no approval event in %+v", events)
+	}
+}
+
+func TestLoginDenied(t *testing.T) {
+	srv := fakeLogin(t, func(int) protocol.PollKeyResponse {
+		return protocol.PollKeyResponse{Response: protocol.Response{Errors: []string{"request denied"}}}
+	})
+	_, _, err := login(t, srv, 5*time.Second)
+	var se *ServerError
+	if !errors.As(err, &se) || se.Response.Errors[0] != "request denied" {
+		t.Errorf("got %v, want the denial", err)
+	}
+}
+
+func TestLoginNoKey(t *testing.T) {
+	srv := fakeLogin(t, func(int) protocol.PollKeyResponse {
+		return protocol.PollKeyResponse{Response: protocol.Response{Success: true}}
+	})
+	if _, _, err := login(t, srv, 5*time.Second); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want an error without waiting for the timeout", err)
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	srv := fakeLogin(t, func(int) protocol.PollKeyResponse {
+		return protocol.PollKeyResponse{Response: protocol.Response{Success: true}, Pending: true}
+	})
+	if _, _, err := login(t, srv, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+}
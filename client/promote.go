@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/xmit-co/xmit/protocol"
+	"github.com/zeebo/blake3"
+)
+
+// PromoteOptions configures Promote
+type PromoteOptions struct {
+	Connection
+
+	Domain string
+	Team   string // team to act for
+	ID     string // upload to make live, or empty for the one preceding the live upload
+}
+
+// Promote makes an earlier upload of opts.Domain live again, without uploading anything.
+// Without opts.ID, it rolls back to the upload preceding the live one.
+func Promote(ctx context.Context, opts PromoteOptions) (*protocol.Upload, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	c, err := connect(ctx, opts.Connection, emit)
+	if err != nil {
+		return nil, err
+	}
+	req := protocol.Request{Key: opts.Key, Team: opts.Team, Domain: opts.Domain}
+
+	var target *protocol.Upload
+	if opts.ID != "" {
+		target, err = resolveUpload(ctx, c, req, opts.ID, emit)
+	} else {
+		target, err = previousUpload(ctx, c, req, emit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bundle := fmt.Sprintf("%x", target.Bundle)
+	if target.Live {
+		emit.warn("upload %s is already live", target.ID)
+		return target, nil
+	}
+	emit.emit(Event{Type: EventPromoting, ID: target.ID, Bundle: bundle})
+
+	finalizeResp, err := c.Finalize(ctx, req, target.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize: %w", err)
+	}
+	if err := emit.check("finalization", finalizeResp.Response); err != nil {
+		return nil, err
+	}
+	emit.emit(Event{Type: EventFinalized, Bundle: bundle})
+	return target, nil
+}
+
+// resolveUpload finds the upload with the given ID through its bundle, so it may be older than
+// the listed uploads; it is live if it has the bundle of the live upload
+func resolveUpload(ctx context.Context, c *protocol.Client, req protocol.Request, id string, emit emitter) (*protocol.Upload, error) {
+	resp, err := c.DownloadBundle(ctx, req, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download bundle: %w", err)
+	}
+	if err := emit.check("bundle download", resp.Response); err != nil {
+		return nil, err
+	}
+	uploads, err := listUploads(ctx, c, req, 0, emit)
+	if err != nil {
+		return nil, err
+	}
+	target := &protocol.Upload{ID: id, Bundle: blake3.Sum256(resp.Bundle)}
+	live := false
+	for _, u := range uploads {
+		if u.ID == id {
+			*target = u
+		}
+		live = live || (u.Live && u.Bundle == target.Bundle)
+	}
+	target.Live = live
+	return target, nil
+}
+
+// previousUpload picks the most recent upload preceding the live one with a different bundle,
+// listing more uploads until one qualifies or none is left
+func previousUpload(ctx context.Context, c *protocol.Client, req protocol.Request, emit emitter) (*protocol.Upload, error) {
+	limit := 0
+	for {
+		uploads, err := listUploads(ctx, c, req, limit, emit)
+		if err != nil {
+			return nil, err
+		}
+		// Uploads are listed most recent first
+		live := slices.IndexFunc(uploads, func(u protocol.Upload) bool { return u.Live })
+		if live >= 0 {
+			for i := live + 1; i < len(uploads); i++ {
+				if uploads[i].Bundle != uploads[live].Bundle {
+					return &uploads[i], nil
+				}
+			}
+		}
+		if len(uploads) == 0 || (limit > 0 && len(uploads) < limit) {
+			if live < 0 {
+				return nil, errors.New("no upload is live")
+			}
+			return nil, errors.New("no upload preceding the live one differs from it")
+		}
+		limit = 2 * len(uploads)
+	}
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveDestination returns the directory a download replaces, following symbolic links, and
+// why it cannot be swapped atomically but only updated in place ("" if it can)
+func resolveDestination(destination string) (string, string, error) {
+	abs, err := filepath.Abs(destination)
+	if err != nil {
+		return "", "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return abs, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("%s is not a directory", destination)
+	}
+	if filepath.Dir(resolved) == resolved {
+		return resolved, "it is the filesystem root", nil
+	}
+	// Swapping the working directory away would leave this process, and the shell it was
+	// started from, in the removed tree
+	if wd, err := os.Getwd(); err == nil {
+		if wd, err := filepath.EvalSymlinks(wd); err == nil {
+			if rel, err := filepath.Rel(resolved, wd); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return resolved, "it contains the working directory", nil
+			}
+		}
+	}
+	return resolved, unrenamable(resolved), nil
+}
+
+// stagingPrefix and oldPrefix start the names of the directories an in-place update creates within
+// its destination; left behind by an interrupted download, they are not part of the destination
+const (
+	stagingPrefix = ".xmit-staging-"
+	oldPrefix     = ".xmit-old-"
+)
+
+// leftover reports whether the entry at slash-separated path rel of a destination was left by an
+// interrupted in-place update
+func leftover(rel string) bool {
+	return strings.HasPrefix(rel, stagingPrefix) || strings.HasPrefix(rel, oldPrefix)
+}
+
+// newStaging creates an empty directory on the same filesystem as destination, with its permissions
+// if it exists: next to it so it can be swapped with it, or within it when updating it in place
+func newStaging(destination string, inPlace bool) (string, error) {
+	dir, prefix := filepath.Dir(destination), "."+filepath.Base(destination)+".xmit-"
+	if inPlace {
+		dir, prefix = destination, stagingPrefix
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(dir, prefix)
+	if err != nil {
+		return "", fmt.Errorf("creating staging directory: %w", err)
+	}
+	mode := fs.FileMode(0755)
+	if info, err := os.Stat(destination); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(staging, mode); err != nil {
+		os.Remove(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// swap puts the content of staging in place of destination, returning where the previous
+// content now lives ("" if there was none) for the caller to remove
+func swap(staging, destination string, inPlace bool) (string, error) {
+	if inPlace {
+		return moveInto(staging, destination)
+	}
+	info, err := os.Lstat(destination)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", os.Rename(staging, destination)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", destination)
+	}
+	return exchange(staging, destination)
+}
+
+// moveInto replaces the entries of destination with those of staging, which lies within it, one at
+// a time, so destination is seen half-updated until it returns
+func moveInto(staging, destination string) (string, error) {
+	old, err := os.MkdirTemp(destination, oldPrefix)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(destination)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(destination, entry.Name())
+		if p == staging || p == old {
+			continue
+		}
+		if err := os.Rename(p, filepath.Join(old, entry.Name())); err != nil {
+			return "", fmt.Errorf("moving %s aside to %s: %w", p, old, err)
+		}
+	}
+	entries, err = os.ReadDir(staging)
+	if err != nil {
+		return "", fmt.Errorf("previous files are in %s: %w", old, err)
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(staging, entry.Name()), filepath.Join(destination, entry.Name())); err != nil {
+			return "", fmt.Errorf("previous files are in %s: %w", old, err)
+		}
+	}
+	return old, nil
+}
+
+// renameAside swaps directories with two renames, leaving a short window where destination does not exist
+func renameAside(staging, destination string) (string, error) {
+	old := staging + ".old"
+	if err := os.Rename(destination, old); err != nil {
+		return "", err
+	}
+	if err := os.Rename(staging, destination); err != nil {
+		if err := os.Rename(old, destination); err != nil {
+			return "", fmt.Errorf("restoring %s from %s: %w", destination, old, err)
+		}
+		return "", err
+	}
+	return old, nil
+}
+
+// carry recreates the entry at src under dst, hard-linking files where possible
+func carry(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return linkFile(p, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot stage %s: unsupported file type", p)
+		}
+	})
+}
+
+// linkFile hard-links src to dst, copying it instead when the filesystem does not allow links
+func linkFile(src, dst string, mode fs.FileMode) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
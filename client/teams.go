@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// TeamsOptions configures Teams and Whoami
+type TeamsOptions struct {
+	Connection
+}
+
+// TeamList holds the teams a key can act for
+type TeamList struct {
+	Teams         []protocol.Team
+	ManagementURL string // page where teams are managed
+}
+
+// Identity describes a key
+type Identity struct {
+	TeamList
+
+	// Described reports whether the server described the key; older servers only list its teams
+	Described bool
+	User      string         // owning user, for user keys
+	Team      *protocol.Team // owning team, for team keys
+	Domains   []string       // domains the key may publish to
+}
+
+// connect discovers the endpoint and creates a client for it
+func connect(ctx context.Context, conn Connection, emit emitter) (*protocol.Client, error) {
+	policy := conn.callPolicy()
+	emit.emit(Event{Type: EventDiscovering})
+	discovery, err := protocol.Discover(ctx, conn.URL, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover endpoint: %w", err)
+	}
+	emit.emit(Event{Type: EventDiscovery, URL: discovery.URL})
+
+	c, err := protocol.NewClient(ctx, discovery.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	c.CallPolicy = policy
+	return c, nil
+}
+
+func listTeams(ctx context.Context, c *protocol.Client, key string, emit emitter) (*TeamList, error) {
+	resp, err := c.ListTeams(ctx, protocol.Request{Key: key})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	if err := emit.check("team listing", resp.Response); err != nil {
+		return nil, err
+	}
+	return &TeamList{Teams: resp.Teams, ManagementURL: resp.ManagementURL}, nil
+}
+
+// Teams lists the teams opts.Key can act for
+func Teams(ctx context.Context, opts TeamsOptions) (*TeamList, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	c, err := connect(ctx, opts.Connection, emit)
+	if err != nil {
+		return nil, err
+	}
+	return listTeams(ctx, c, opts.Key, emit)
+}
+
+// Whoami validates opts.Key, listing its teams, and describes its owner and domains
+func Whoami(ctx context.Context, opts TeamsOptions) (*Identity, error) {
+	emit := emitter(opts.OnEvent)
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	c, err := connect(ctx, opts.Connection, emit)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := listTeams(ctx, c, opts.Key, emit)
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{TeamList: *teams}
+
+	resp, err := c.KeyInfo(ctx, protocol.Request{Key: opts.Key})
+	var se *protocol.StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return id, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe key: %w", err)
+	}
+	if err := emit.check("key description", resp.Response); err != nil {
+		return nil, err
+	}
+	id.Described = true
+	id.User = resp.User
+	id.Team = resp.Team
+	id.Domains = resp.Domains
+	return id, nil
+}
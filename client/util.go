@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xmit-co/xmit/protocol"
+)
+
+// Connection configures how operations reach the service and report their progress;
+// the options of every operation embed it
+type Connection struct {
+	URL string // service base URL (default: protocol.DefaultURL)
+	Key string // API key, unused by Login
+
+	Retry          *protocol.RetryPolicy // default: protocol.DefaultRetryPolicy
+	RequestTimeout time.Duration         // deadline for each request attempt (default: protocol.DefaultRequestTimeout, negative for none)
+
+	OnEvent func(Event)
+}
+
+// callPolicy applies the retry policy and per-attempt deadline over protocol.DefaultCallPolicy
+func (c Connection) callPolicy() protocol.CallPolicy {
+	policy := protocol.DefaultCallPolicy
+	if c.Retry != nil {
+		policy.Retry = *c.Retry
+	}
+	if c.RequestTimeout != 0 {
+		policy.RequestTimeout = max(c.RequestTimeout, 0)
+	}
+	return policy
+}
+
+func chunkSlice[T any](data []T, size func(T) int64, maxSize int64) [][]T {
+	var result [][]T
+	var currentChunk []T
+	var currentSize int64
+
+	for _, item := range data {
+		itemSize := size(item)
+		// If adding this item to the current chunk exceeds maxSize, add the current chunk to result
+		// and start a new chunk.
+		if currentSize+itemSize > maxSize && currentSize > 0 {
+			result = append(result, currentChunk)
+			currentChunk = nil
+			currentSize = 0
+		}
+		// Add the item to the current chunk and update the current size.
+		currentChunk = append(currentChunk, item)
+		currentSize += itemSize
+	}
+	// Add the last chunk if it's not empty.
+	if len(currentChunk) > 0 {
+		result = append(result, currentChunk)
+	}
+
+	return result
+}
+
+// parallelize calls fn for each index below n, at most parallelism at a time.
+// It stops starting calls after the first error, which it returns once running calls end.
+func parallelize(ctx context.Context, n, parallelism int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, max(1, parallelism))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := range n {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/titanous/json5"
+)
+
 type Redirect struct {
 	From      string `toml:"from" json:"from" json5:"from"`
 	To        string `toml:"to" json:"to" json5:"to"`
@@ -24,4 +33,34 @@ type XmitConfig struct {
 	Headers   []Header   `toml:"headers" json:"headers" json5:"headers"`
 	Redirects []Redirect `toml:"redirects" json:"redirects" json5:"redirects"`
 	Forms     []Form     `toml:"forms" json:"forms" json5:"forms"`
+	// Ignore lists gitignore-style patterns, relative to the site root, excluded from uploads
+	Ignore []string `toml:"ignore" json:"ignore" json5:"ignore"`
+	// Team is the team uploads and downloads act for, unless overridden
+	Team string `toml:"team" json:"team" json5:"team"`
+}
+
+// Load reads xmit.json (or, failing that, xmit.toml) from directory.
+// A missing configuration yields an empty XmitConfig and no error.
+func Load(directory string) (XmitConfig, error) {
+	cfg := XmitConfig{}
+	jsonPath := filepath.Join(directory, "xmit.json")
+	tomlPath := filepath.Join(directory, "xmit.toml")
+	cfgBytes, err := os.ReadFile(jsonPath)
+	if err == nil {
+		if err = json5.Unmarshal(cfgBytes, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", jsonPath, err)
+		}
+		return cfg, nil
+	}
+	if !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("%s: %w", jsonPath, err)
+	}
+	cfgBytes, err = os.ReadFile(tomlPath)
+	if err == nil {
+		err = toml.Unmarshal(cfgBytes, &cfg)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("%s: %w", tomlPath, err)
+	}
+	return cfg, nil
 }
@@ -17,7 +17,7 @@
             pname = "xmit";
             version = "0.5.0";  
             src = ./.;
-            vendorHash = "sha256-gKFRvDOxaefccq/jImge13xGQy86xV0AF3ZnFAMr8G8=";
+            vendorHash = "sha256-zuQdD3ZUm2Y4FG1612i1/omi3iVkXbbqW4ErKOQFXjU=";
           };
         }
     );
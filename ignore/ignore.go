@@ -0,0 +1,200 @@
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the per-directory ignore file
+const FileName = ".xmitignore"
+
+type pattern struct {
+	base    string // slash-separated directory the pattern is relative to ("" for the root)
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher evaluates gitignore-style patterns collected from nested ignore files.
+// Later patterns take precedence over earlier ones, so patterns from deeper
+// directories override those of their parents.
+type Matcher struct {
+	patterns []pattern
+}
+
+// New creates a matcher from patterns relative to the root directory
+func New(lines []string) *Matcher {
+	m := &Matcher{}
+	for _, line := range lines {
+		if p, ok := compile("", line); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return m
+}
+
+// WithFile returns a matcher extended with the ignore file found in dir, if any.
+// rel is the slash-separated path of dir relative to the root.
+func (m *Matcher) WithFile(dir, rel string) (*Matcher, error) {
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	patterns, err := parse(rel, f)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return m, nil
+	}
+	return &Matcher{patterns: append(m.patterns[:len(m.patterns):len(m.patterns)], patterns...)}, nil
+}
+
+// Ignored reports whether the slash-separated path rel (relative to the root) is ignored
+func (m *Matcher) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if p.base != "" {
+			if !strings.HasPrefix(rel, p.base+"/") {
+				continue
+			}
+			sub = rel[len(p.base)+1:]
+		}
+		if p.re.MatchString(sub) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func parse(base string, r io.Reader) ([]pattern, error) {
+	var patterns []pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p, ok := compile(base, scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+func compile(base, line string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return pattern{}, false
+	}
+	p := pattern{base: base}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+	// A slash at the beginning or in the middle anchors the pattern to its base directory
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	b.WriteString(translate(line))
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// translate converts a glob to a regular expression fragment
+func translate(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				atEnd := i+2 == len(glob)
+				if atStart && atEnd {
+					b.WriteString(".*")
+					i++
+					continue
+				}
+				if atStart && glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if class == "" {
+				// "[]" cannot close immediately; treat the bracket literally
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteByte('[')
+			if class[0] == '!' {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			b.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			b.WriteByte(']')
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-2] + " "
+	}
+	return line
+}
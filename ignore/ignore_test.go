@@ -0,0 +1,182 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type check struct {
+	path  string
+	isDir bool
+	want  bool
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		checks   []check
+	}{
+		{"unanchored name", []string{"*.log"}, []check{
+			{"a.log", false, true},
+			{"deep/dir/a.log", false, true},
+			{"a.log.txt", false, false},
+			{"logs", true, false},
+		}},
+		{"leading slash anchors", []string{"/todo.txt"}, []check{
+			{"todo.txt", false, true},
+			{"sub/todo.txt", false, false},
+		}},
+		{"middle slash anchors", []string{"doc/*.md"}, []check{
+			{"doc/a.md", false, true},
+			{"sub/doc/a.md", false, false},
+			{"doc/sub/a.md", false, false},
+		}},
+		{"trailing slash matches directories only", []string{"build/"}, []check{
+			{"build", true, true},
+			{"sub/build", true, true},
+			{"build", false, false},
+		}},
+		{"leading double star", []string{"**/cache"}, []check{
+			{"cache", true, true},
+			{"a/b/cache", false, true},
+			{"a/cache2", false, false},
+		}},
+		{"middle double star", []string{"a/**/b"}, []check{
+			{"a/b", false, true},
+			{"a/x/b", false, true},
+			{"a/x/y/b", false, true},
+			{"x/a/b", false, false},
+		}},
+		{"trailing double star", []string{"vendor/**"}, []check{
+			{"vendor/x", false, true},
+			{"vendor/x/y", true, true},
+			{"vendor", true, false},
+		}},
+		{"single star stays within a directory", []string{"/a*"}, []check{
+			{"abc", false, true},
+			{"abc/d", false, false},
+		}},
+		{"question mark", []string{"?.txt"}, []check{
+			{"a.txt", false, true},
+			{"ab.txt", false, false},
+		}},
+		{"character classes", []string{"[ab].txt", "[!0-9].md"}, []check{
+			{"a.txt", false, true},
+			{"c.txt", false, false},
+			{"x.md", false, true},
+			{"1.md", false, false},
+		}},
+		{"unclosed bracket is literal", []string{"[a"}, []check{
+			{"[a", false, true},
+			{"a", false, false},
+		}},
+		{"negation re-includes", []string{"*.log", "!keep.log"}, []check{
+			{"a.log", false, true},
+			{"keep.log", false, false},
+		}},
+		{"later patterns take precedence", []string{"!keep.log", "*.log"}, []check{
+			{"keep.log", false, true},
+		}},
+		{"re-including a file of an excluded directory's content", []string{"build/*", "!build/keep.txt"}, []check{
+			{"build", true, false},
+			{"build/out.js", false, true},
+			{"build/keep.txt", false, false},
+		}},
+		{"excluded directory is ignored despite re-included files", []string{"build/", "!build/keep.txt"}, []check{
+			{"build", true, true},
+		}},
+		{"comments and escapes", []string{"# comment", `\#hash`, `\!bang`, ""}, []check{
+			{"# comment", false, false},
+			{"#hash", false, true},
+			{"!bang", false, true},
+		}},
+		{"trailing spaces", []string{"a.txt   ", `b\ `}, []check{
+			{"a.txt", false, true},
+			{"b ", false, true},
+			{"b", false, false},
+		}},
+		{"escaped glob characters", []string{`\*.txt`}, []check{
+			{"*.txt", false, true},
+			{"a.txt", false, false},
+		}},
+		{"regexp metacharacters are literal", []string{"a+b(c).txt"}, []check{
+			{"a+b(c).txt", false, true},
+			{"aab(c).txt", false, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(tt.patterns)
+			for _, c := range tt.checks {
+				if got := m.Ignored(c.path, c.isDir); got != c.want {
+					t.Errorf("Ignored(%q, %v) = %v, want %v", c.path, c.isDir, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNestedFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(FileName, "*.tmp\n/top.txt\nsecret/\n")
+	write("sub/"+FileName, "!keep.tmp\n/local.txt\ntop.txt\n")
+	write("sub/deep/"+FileName, "keep.tmp\n")
+
+	matcher := func(rels ...string) *Matcher {
+		t.Helper()
+		m := New([]string{"*.bak"})
+		for _, rel := range rels {
+			var err error
+			m, err = m.WithFile(filepath.Join(root, filepath.FromSlash(rel)), rel)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return m
+	}
+	rootM := matcher("")
+	subM := matcher("", "sub")
+	deepM := matcher("", "sub", "sub/deep")
+	otherM := matcher("", "other") // no ignore file
+
+	tests := []struct {
+		name string
+		m    *Matcher
+		check
+	}{
+		{"configured pattern", rootM, check{"a.bak", false, true}},
+		{"root pattern", rootM, check{"a.tmp", false, true}},
+		{"root pattern applies below", subM, check{"sub/a.tmp", false, true}},
+		{"unanchored in a subdirectory file", subM, check{"sub/top.txt", false, true}},
+		{"root anchor", rootM, check{"top.txt", false, true}},
+		{"deeper file re-includes", subM, check{"sub/keep.tmp", false, false}},
+		{"re-inclusion applies below", subM, check{"sub/x/keep.tmp", false, false}},
+		{"re-inclusion only below its directory", subM, check{"keep.tmp", false, true}},
+		{"deepest file wins", deepM, check{"sub/deep/keep.tmp", false, true}},
+		{"sibling unaffected by deeper file", deepM, check{"sub/keep.tmp", false, false}},
+		{"anchored to its own directory", subM, check{"sub/local.txt", false, true}},
+		{"anchor does not reach below", subM, check{"sub/x/local.txt", false, false}},
+		{"anchor does not reach the root", subM, check{"local.txt", false, false}},
+		{"directory only pattern", subM, check{"sub/secret", true, true}},
+		{"directory only pattern on a file", subM, check{"sub/secret", false, false}},
+		{"directory without ignore file", otherM, check{"other/a.tmp", false, true}},
+		{"ignore file itself is not a pattern", rootM, check{FileName, false, false}},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%s: Ignored(%q, %v) = %v, want %v", tt.name, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -1,31 +1,291 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/kirsle/configdir"
+	"github.com/pelletier/go-toml/v2"
 )
 
 var (
-	keyPath = path.Join(configdir.LocalConfig("xmit"), "key")
+	configDir     = configdir.LocalConfig("xmit")
+	keyPath       = path.Join(configDir, "key")
+	keysDir       = path.Join(configDir, "keys")
+	profilesPath  = path.Join(configDir, "profiles.toml")
+	hashCachePath = path.Join(configDir, "hashes")
 )
 
-func findKey() string {
+// defaultProfile is used when no profile is selected; its key lives in the original key file
+const defaultProfile = "default"
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// profile holds the settings of a named key
+type profile struct {
+	URL   string `toml:"url,omitempty"`   // service URL, overriding the default one
+	Store string `toml:"store,omitempty"` // secret store holding the key (default: file)
+}
+
+// Secret store names
+const (
+	fileStoreName     = "file"
+	keychainStoreName = "keychain"
+)
+
+// secretStore keeps the API keys of profiles
+type secretStore interface {
+	// lookup returns the key of a profile, or "" if it has none
+	lookup(name string) (string, error)
+	store(name, key string) error
+	remove(name string) error
+}
+
+// secretStores are the available secret stores, by name
+var secretStores = map[string]secretStore{
+	fileStoreName:     fileStore{},
+	keychainStoreName: keychainStore{},
+}
+
+// storeName returns the name of the secret store holding the key of the profile
+func (p profile) storeName() string {
+	return cmp.Or(p.Store, fileStoreName)
+}
+
+func (p profile) secrets() secretStore {
+	if s, found := secretStores[p.storeName()]; found {
+		return s
+	}
+	return secretStores[fileStoreName]
+}
+
+// fileStore keeps keys in files of the config directory, readable only by their owner
+type fileStore struct{}
+
+func (fileStore) lookup(name string) (string, error) {
+	b, err := os.ReadFile(profileKeyPath(name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b)), err
+}
+
+func (fileStore) store(name, key string) error {
+	p := profileKeyPath(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(key), 0600)
+}
+
+func (fileStore) remove(name string) error {
+	if err := os.Remove(profileKeyPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type profilesFile struct {
+	Profiles map[string]profile `toml:"profiles"`
+}
+
+func loadProfiles() (map[string]profile, error) {
+	var f profilesFile
+	b, err := os.ReadFile(profilesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := toml.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("%s: %w", profilesPath, err)
+		}
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]profile)
+	}
+	// The default profile exists as soon as its key does, even without an entry
+	if _, err := os.Stat(keyPath); err == nil {
+		if _, found := f.Profiles[defaultProfile]; !found {
+			f.Profiles[defaultProfile] = profile{}
+		}
+	}
+	return f.Profiles, nil
+}
+
+func saveProfiles(profiles map[string]profile) error {
+	b, err := toml.Marshal(profilesFile{Profiles: profiles})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(profilesPath, b, 0600)
+}
+
+// profileNames lists the known profiles, sorted
+func profileNames(profiles map[string]profile) []string {
+	return slices.Sorted(maps.Keys(profiles))
+}
+
+// selectedProfile returns name, or the profile selected by the environment
+func selectedProfile(name string) string {
+	if name == "" {
+		name = os.Getenv("XMIT_PROFILE")
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	return name
+}
+
+func checkProfileName(name string) error {
+	if !profileNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, '-', '_' and '.')", name)
+	}
+	return nil
+}
+
+// findProfile returns the settings of a profile, which must exist unless it is the default one
+func findProfile(name string) (profile, error) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return profile{}, err
+	}
+	p, found := profiles[name]
+	if !found && name != defaultProfile {
+		return p, fmt.Errorf("unknown profile %q (see 'xmit profiles list')", name)
+	}
+	return p, nil
+}
+
+func profileKeyPath(name string) string {
+	if name == defaultProfile {
+		return keyPath
+	}
+	return path.Join(keysDir, name)
+}
+
+// findKey returns the key from XMIT_KEY, the file named by XMIT_KEY_FILE, the output of
+// XMIT_KEY_COMMAND, or else the store of the profile; it is "" if none was configured
+func findKey(name string) (string, error) {
 	if key, found := os.LookupEnv("XMIT_KEY"); found {
-		return key
+		return key, nil
+	}
+	if p := os.Getenv("XMIT_KEY_FILE"); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", fmt.Errorf("failed to read XMIT_KEY_FILE: %w", err)
+		}
+		key := strings.TrimSpace(string(b))
+		if key == "" {
+			return "", fmt.Errorf("XMIT_KEY_FILE %s is empty", p)
+		}
+		return key, nil
+	}
+	if command := os.Getenv("XMIT_KEY_COMMAND"); command != "" {
+		return runKeyCommand(command, name)
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return "", err
 	}
-	if b, err := os.ReadFile(keyPath); err == nil {
-		return strings.TrimSpace(string(b))
+	key, err := profiles[name].secrets().lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key of profile %s: %w", name, err)
 	}
-	return ""
+	return key, nil
 }
 
-func storeKey(key string) error {
-	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+// runKeyCommand runs a credential helper through the shell, its standard output being the key.
+// The helper finds the selected profile in XMIT_PROFILE, and can prompt on the terminal.
+func runKeyCommand(command, name string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "XMIT_PROFILE="+name)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("XMIT_KEY_COMMAND %q failed: %w", command, err)
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", fmt.Errorf("XMIT_KEY_COMMAND %q printed no key", command)
+	}
+	return key, nil
+}
+
+// storeKey stores the key of a profile, creating it if needed.
+// A non-empty url replaces its URL, and a non-empty store moves its key to that secret store;
+// keys fall back to the file store when the keychain is unavailable.
+func storeKey(name, key, url, store string) error {
+	if err := checkProfileName(name); err != nil {
+		return err
+	}
+	if _, found := secretStores[store]; store != "" && !found {
+		return fmt.Errorf("unknown secret store %q (use %s)", store, strings.Join(slices.Sorted(maps.Keys(secretStores)), " or "))
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	previous := profiles[name]
+	entry := previous
+	if url != "" {
+		entry.URL = url
+	}
+	if store != "" {
+		entry.Store = store
+	}
+	if err := entry.secrets().store(name, key); err != nil {
+		if entry.Store != keychainStoreName {
+			return err
+		}
+		log.Printf("⚠️ Keychain unavailable (%v), storing the API key in %s instead", err, profileKeyPath(name))
+		entry.Store = fileStoreName
+		if err := entry.secrets().store(name, key); err != nil {
+			return err
+		}
+	}
+	// Do not leave a stale copy behind in the store the key moved from
+	if previous.storeName() != entry.storeName() {
+		if err := previous.secrets().remove(name); err != nil {
+			log.Printf("⚠️ Failed to remove the previous API key of profile %s: %v", name, err)
+		}
+	}
+	profiles[name] = entry
+	return saveProfiles(profiles)
+}
+
+// removeProfile forgets a profile and deletes its key
+func removeProfile(name string) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	p, found := profiles[name]
+	if !found {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if err := p.secrets().remove(name); err != nil {
 		return err
 	}
-	return os.WriteFile(keyPath, []byte(key), 0600)
+	delete(profiles, name)
+	return saveProfiles(profiles)
 }
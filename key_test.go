@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const memStoreName = "memory"
+
+// memStore keeps keys in memory
+type memStore struct {
+	keys map[string]string
+}
+
+func (s *memStore) lookup(name string) (string, error) {
+	return s.keys[name], nil
+}
+
+func (s *memStore) store(name, key string) error {
+	s.keys[name] = key
+	return nil
+}
+
+func (s *memStore) remove(name string) error {
+	delete(s.keys, name)
+	return nil
+}
+
+// testConfig points the configuration to a temporary directory, registers a memory store
+// and clears the environment selecting keys
+func testConfig(t *testing.T) *memStore {
+	t.Helper()
+	dir := t.TempDir()
+	saved := []string{configDir, keyPath, keysDir, profilesPath}
+	configDir = dir
+	keyPath = path.Join(dir, "key")
+	keysDir = path.Join(dir, "keys")
+	profilesPath = path.Join(dir, "profiles.toml")
+	mem := &memStore{keys: make(map[string]string)}
+	secretStores[memStoreName] = mem
+	t.Cleanup(func() {
+		configDir, keyPath, keysDir, profilesPath = saved[0], saved[1], saved[2], saved[3]
+		delete(secretStores, memStoreName)
+	})
+	for _, name := range []string{"XMIT_KEY", "XMIT_KEY_FILE", "XMIT_KEY_COMMAND", "XMIT_PROFILE"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return mem
+}
+
+func expectKey(t *testing.T, name, want string) {
+	t.Helper()
+	key, err := findKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != want {
+		t.Errorf("findKey(%q) = %q, want %q", name, key, want)
+	}
+}
+
+func TestFindKeyPrecedence(t *testing.T) {
+	testConfig(t)
+	expectKey(t, defaultProfile, "")
+
+	if err := storeKey("work", "profile-key", "", memStoreName); err != nil {
+		t.Fatal(err)
+	}
+	expectKey(t, "work", "profile-key")
+
+	t.Setenv("XMIT_KEY_COMMAND", "echo command-key-$XMIT_PROFILE")
+	expectKey(t, "work", "command-key-work")
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XMIT_KEY_FILE", keyFile)
+	expectKey(t, "work", "file-key")
+
+	t.Setenv("XMIT_KEY", "env-key")
+	expectKey(t, "work", "env-key")
+}
+
+func TestFindKeyErrors(t *testing.T) {
+	testConfig(t)
+	t.Setenv("XMIT_KEY_COMMAND", "true")
+	if _, err := findKey(defaultProfile); err == nil || !strings.Contains(err.Error(), "printed no key") {
+		t.Errorf("got %v for a command printing nothing", err)
+	}
+	t.Setenv("XMIT_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := findKey(defaultProfile); err == nil || !strings.Contains(err.Error(), "XMIT_KEY_FILE") {
+		t.Errorf("got %v for a missing key file", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	mem := testConfig(t)
+
+	if err := storeKey("default", "default-key", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(keyPath); err != nil || string(b) != "default-key" {
+		t.Errorf("default key file holds %q (%v)", b, err)
+	}
+	if err := storeKey("work", "work-key", "https://example.com", memStoreName); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(profileNames(profiles), ","); got != "default,work" {
+		t.Errorf("profiles are %s", got)
+	}
+	if p := profiles["work"]; p.URL != "https://example.com" || p.Store != memStoreName {
+		t.Errorf("work profile is %+v", p)
+	}
+	expectKey(t, "work", "work-key")
+
+	// Moving the key to another store removes it from the previous one
+	if err := storeKey("work", "new-key", "", fileStoreName); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mem.keys["work"]; found {
+		t.Error("key left in the previous store")
+	}
+	expectKey(t, "work", "new-key")
+	if p, err := findProfile("work"); err != nil || p.URL != "https://example.com" {
+		t.Errorf("work profile is %+v (%v) after moving its key", p, err)
+	}
+
+	if err := removeProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(profileKeyPath("work")); !os.IsNotExist(err) {
+		t.Errorf("key of removed profile still stored (%v)", err)
+	}
+	if _, err := findProfile("work"); err == nil {
+		t.Error("removed profile still found")
+	}
+	if err := removeProfile("work"); err == nil {
+		t.Error("removing an unknown profile succeeded")
+	}
+
+	if err := storeKey("bad/name", "k", "", ""); err == nil {
+		t.Error("invalid profile name accepted")
+	}
+	if err := storeKey("other", "k", "", "vault"); err == nil {
+		t.Error("unknown store accepted")
+	}
+}
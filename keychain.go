@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keychainService names the keychain entries of xmit, one per profile
+const keychainService = "xmit"
+
+// keychainStore keeps keys in the OS keychain: the Secret Service over D-Bus (GNOME Keyring,
+// KWallet…) on Linux and BSDs, the login keychain on macOS and the Credential Manager on Windows
+type keychainStore struct{}
+
+func (keychainStore) lookup(name string) (string, error) {
+	key, err := keyring.Get(keychainService, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	return key, err
+}
+
+func (keychainStore) store(name, key string) error {
+	return keyring.Set(keychainService, name, key)
+}
+
+func (keychainStore) remove(name string) error {
+	err := keyring.Delete(keychainService, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
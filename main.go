@@ -1,39 +1,172 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/xmit-co/xmit/client"
 	"github.com/xmit-co/xmit/preview"
+	"github.com/xmit-co/xmit/protocol"
 	"golang.org/x/term"
 )
 
-func findDirectory() string {
-	var directory string
-	if len(os.Args) > 2 {
-		directory = os.Args[2]
-	} else if _, err := os.Stat("dist"); !os.IsNotExist(err) {
-		directory = "dist"
-	} else {
-		directory = "."
+// command is a CLI subcommand; setup registers its flags and returns the function running it
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+// usageError reports invalid positional arguments, and triggers the command's usage
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"upload", "DOMAIN [DIRECTORY]", "upload DIRECTORY (default: dist if present, else .) to DOMAIN", uploadCommand},
+		{"download", "DOMAIN[@ID] DIRECTORY | DOMAIN[@ID] -o FILE", "download from DOMAIN to DIRECTORY or an archive (specify an upload ID or omit ID for latest)", downloadCommand},
+		{"history", "DOMAIN", "list the past uploads of DOMAIN (* marks the live one)", historyCommand},
+		{"rollback", "DOMAIN", "make the upload preceding the live one of DOMAIN live again", promoteCommand(true)},
+		{"promote", "DOMAIN@ID", "make an earlier upload of DOMAIN live again", promoteCommand(false)},
+		{"diff", "DOMAIN[@ID] [DIRECTORY | DOMAIN@ID]", "list the files of DIRECTORY (default: dist if present, else .) added (A), removed (D) or modified (M) since an upload of DOMAIN; a second DOMAIN@ID compares two uploads instead", diffCommand},
+		{"preview", "[DIRECTORY]", "serve a preview locally", previewCommand},
+		{"whoami", "", "check your API key and show who it belongs to", whoamiCommand},
+		{"teams", "", "list the teams your API key can act for", teamsCommand},
+		{"login", "", "obtain an API key by approving it in your browser", loginCommand},
+		{"set-key", "[KEY | -]", "configure your API key (- reads it from standard input)", setKeyCommand},
+		{"profiles", "[list | remove NAME]", "list or remove key profiles", profilesCommand},
+		{"help", "[COMMAND]", "show help for xmit or a command", helpCommand},
 	}
-	directory, err := filepath.Abs(directory)
-	if err != nil {
-		log.Fatalf("🛑 Failed to get absolute path: %v", err)
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
 	}
-	return directory
+	return nil
 }
 
 func usage() {
 	fmt.Println("Usage:")
-	fmt.Println("  xmit set-key [KEY] (or set XMIT_KEY) → configure your API key")
-	fmt.Println("  xmit DOMAIN [DIRECTORY] → upload to DOMAIN")
-	fmt.Println("  xmit preview [DIRECTORY] → serve a preview locally (set LISTEN to override :4000)")
-	fmt.Println("  xmit download DOMAIN[@ID] DIRECTORY → download from DOMAIN to DIRECTORY (specify an upload ID or omit ID for latest)")
+	for _, c := range commands {
+		fmt.Printf("  xmit %s %s → %s\n", c.name, c.args, c.summary)
+	}
+	fmt.Println("  xmit DOMAIN [DIRECTORY] → shorthand for 'xmit upload DOMAIN [DIRECTORY]'")
+	fmt.Println("Run 'xmit COMMAND --help' for the options of a command.")
+}
+
+func envString(name, fallback string) string {
+	return cmp.Or(os.Getenv(name), fallback)
+}
+
+func envInt(name string, fallback int) int {
+	if s := os.Getenv(name); s != "" {
+		if v, err := strconv.Atoi(s); err == nil {
+			return v
+		}
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if s := os.Getenv(name); s != "" {
+		if v, err := time.ParseDuration(s); err == nil {
+			return v
+		}
+	}
+	return fallback
+}
+
+func envBool(name string) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && v
+}
+
+// parseArgs parses flags interspersed with positional arguments, which it returns
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// Everything after a "--" terminator is positional
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func run(c *command, args []string) int {
+	fs := flag.NewFlagSet("xmit "+c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: xmit %s %s\n  %s\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	runner := c.setup(fs)
+	positional, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	// The first interrupt cancels the command, letting it abort cleanly; a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err = runner(ctx, positional)
+	if ctx.Err() != nil && err != nil {
+		err = fmt.Errorf("interrupted: %w", err)
+	}
+	var ue usageError
+	if errors.As(err, &ue) {
+		fmt.Fprintf(fs.Output(), "🛑 %v\n", ue)
+		fs.Usage()
+		return 2
+	}
+	if errors.Is(err, client.ErrNoKey) {
+		err = fmt.Errorf("%w: run 'xmit login' or 'xmit set-key' (with --profile to choose another profile), or set XMIT_KEY", err)
+	}
+	if err != nil {
+		log.Printf("🛑 %v", err)
+		return 1
+	}
+	return 0
 }
 
 func main() {
@@ -42,63 +175,716 @@ func main() {
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
-
-	if command == "-h" || command == "--help" {
+	name := os.Args[1]
+	if name == "-h" || name == "--help" {
 		usage()
 		os.Exit(0)
 	}
 
-	if command == "set-key" {
-		var key string
-		if len(os.Args) > 2 {
-			key = os.Args[2]
+	c := findCommand(name)
+	args := os.Args[2:]
+	if c == nil && strings.Contains(name, ".") && !strings.HasPrefix(name, "-") {
+		// Shorthand: xmit DOMAIN [DIRECTORY]
+		c = findCommand("upload")
+		args = os.Args[1:]
+	}
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "🛑 Unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(run(c, args))
+}
+
+// requestFlags control how requests are attempted
+type requestFlags struct {
+	retries        int
+	requestTimeout time.Duration
+}
+
+func addRequestFlags(fs *flag.FlagSet) *requestFlags {
+	r := &requestFlags{}
+	fs.IntVar(&r.retries, "retries", envInt("XMIT_RETRIES", protocol.DefaultRetryPolicy.Retries), "retries of requests failing with network errors or 429/5xx statuses (env XMIT_RETRIES)")
+	fs.DurationVar(&r.requestTimeout, "request-timeout", envDuration("XMIT_REQUEST_TIMEOUT", protocol.DefaultRequestTimeout), "deadline for each request attempt, 0 for none (env XMIT_REQUEST_TIMEOUT)")
+	return r
+}
+
+// endpointFlags are the flags shared by commands talking to the xmit service
+type endpointFlags struct {
+	*requestFlags
+	profile string
+	url     string
+	key     string
+	timeout time.Duration
+}
+
+func addEndpointFlags(fs *flag.FlagSet) *endpointFlags {
+	e := &endpointFlags{}
+	addProfileFlag(fs, &e.profile)
+	fs.StringVar(&e.url, "url", "", "service `URL` (env XMIT_URL, default: the profile's URL or "+protocol.DefaultURL+")")
+	fs.StringVar(&e.key, "key", "", "API `key` (env XMIT_KEY, or XMIT_KEY_FILE naming a file, or XMIT_KEY_COMMAND printing it; default: the key stored for the profile by 'xmit set-key')")
+	e.requestFlags = addRequestFlags(fs)
+	fs.DurationVar(&e.timeout, "timeout", envDuration("XMIT_TIMEOUT", 0), "deadline for the whole operation, 0 for none (env XMIT_TIMEOUT)")
+	return e
+}
+
+// withTimeout applies the whole-operation deadline, if any
+func (e *endpointFlags) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.timeout > 0 {
+		return context.WithTimeout(ctx, e.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Defaults of --team, depending on whether the command reads the project's xmit.toml/xmit.json
+const (
+	projectTeam = "the team of the directory's xmit.toml/xmit.json, else the key's default team"
+	keyTeam     = "the key's default team"
+)
+
+// addTeamFlag registers the --team flag, documenting the team used when it is empty
+func addTeamFlag(fs *flag.FlagSet, fallback string) *string {
+	return fs.String("team", envString("XMIT_TEAM", ""), "`ID` of the team to act for (env XMIT_TEAM, default: "+fallback+")")
+}
+
+// addProfileFlag registers the --profile flag; empty means XMIT_PROFILE or the default profile
+func addProfileFlag(fs *flag.FlagSet, name *string) {
+	fs.StringVar(name, "profile", "", "`name` of the key profile to use (env XMIT_PROFILE, default: "+defaultProfile+")")
+}
+
+// addStoreFlag registers the --store flag, choosing where a stored key is kept
+func addStoreFlag(fs *flag.FlagSet) *string {
+	return fs.String("store", envString("XMIT_KEY_STORE", ""), "where to keep the key: "+fileStoreName+" or "+keychainStoreName+" (env XMIT_KEY_STORE, default: the profile's current store, else "+fileStoreName+")")
+}
+
+// serviceURL picks the explicit URL, else XMIT_URL, else the profile's URL, else the default one
+func serviceURL(explicit string, p profile) string {
+	return cmp.Or(explicit, os.Getenv("XMIT_URL"), p.URL, protocol.DefaultURL)
+}
+
+// resolve fills in the URL and key left unset from the environment and the selected profile,
+// failing with client.ErrNoKey when no key is found
+func (e *endpointFlags) resolve() error {
+	e.profile = selectedProfile(e.profile)
+	p, err := findProfile(e.profile)
+	if err != nil {
+		return err
+	}
+	e.url = serviceURL(e.url, p)
+	if e.key == "" {
+		e.key, err = findKey(e.profile)
+	}
+	if err == nil && e.key == "" {
+		err = fmt.Errorf("%w for profile %s", client.ErrNoKey, e.profile)
+	}
+	return err
+}
+
+func findDirectory(args []string) (string, error) {
+	var directory string
+	if len(args) > 0 {
+		directory = args[0]
+	} else if _, err := os.Stat("dist"); !os.IsNotExist(err) {
+		directory = "dist"
+	} else {
+		directory = "."
+	}
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return directory, nil
+}
+
+// splitDomainID splits DOMAIN[@ID]
+func splitDomainID(s string) (string, string) {
+	domain, id, _ := strings.Cut(s, "@")
+	return domain, id
+}
+
+// jsonOutput registers the --json flag, returning the writer events should be encoded to
+func jsonOutput(fs *flag.FlagSet) func() io.Writer {
+	enabled := fs.Bool("json", false, "emit newline-delimited JSON events on stdout")
+	return func() io.Writer {
+		if *enabled {
+			return os.Stdout
+		}
+		return nil
+	}
+}
+
+// connection fills the client options reaching the service at url with key, reporting to onEvent
+func (r *requestFlags) connection(url, key string, onEvent func(client.Event)) client.Connection {
+	policy := protocol.DefaultRetryPolicy
+	policy.Retries = r.retries
+	timeout := r.requestTimeout
+	if timeout == 0 {
+		timeout = -1 // none, where client options take 0 for the default
+	}
+	return client.Connection{URL: url, Key: key, Retry: &policy, RequestTimeout: timeout, OnEvent: onEvent}
+}
+
+func uploadCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	opts := client.DeployOptions{CachePath: hashCachePath}
+	team := addTeamFlag(fs, projectTeam)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only report what would be uploaded")
+	fs.IntVar(&opts.Parallelism, "parallelism", envInt("UPLOAD_PARALLELISM", 3), "concurrent chunk uploads (env UPLOAD_PARALLELISM)")
+	fs.IntVar(&opts.HashParallelism, "hash-parallelism", envInt("HASH_PARALLELISM", runtime.GOMAXPROCS(0)), "concurrent file hashing workers (env HASH_PARALLELISM)")
+	fs.BoolVar(&opts.Rehash, "rehash", envBool("XMIT_REHASH"), "ignore the local hash cache and rehash every file (env XMIT_REHASH)")
+	output := jsonOutput(fs)
+	return func(ctx context.Context, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return usageError("expected DOMAIN [DIRECTORY]")
+		}
+		directory, err := findDirectory(args[1:])
+		if err != nil {
+			return err
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		report := newReporter(output())
+		opts.Connection = e.connection(e.url, e.key, report.emit)
+		opts.Team = *team
+		opts.Domain = args[0]
+		opts.Directory = directory
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		if _, err := client.Deploy(ctx, opts); err != nil {
+			report.emit(client.Event{Type: client.EventError, Message: err.Error()})
+			return err
+		}
+		return nil
+	}
+}
+
+func downloadCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	opts := client.FetchOptions{}
+	team := addTeamFlag(fs, keyTeam)
+	fs.IntVar(&opts.Parallelism, "parallelism", envInt("DOWNLOAD_PARALLELISM", 3), "concurrent part downloads (env DOWNLOAD_PARALLELISM)")
+	fs.BoolVar(&opts.Delete, "delete", false, "mirror the upload, deleting files and directories of DIRECTORY it lacks")
+	fs.BoolVar(&opts.Force, "force", false, "with --delete, mirror into a non-empty DIRECTORY that no download created")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only report what would be downloaded and deleted")
+	fs.BoolVar(&opts.InPlace, "in-place", false, "update DIRECTORY entry by entry when it cannot be swapped atomically (e.g. it holds the working directory or is a mount point)")
+	archive := fs.String("o", "", "write an archive to `FILE` instead of a directory, - for stdout")
+	format := fs.String("format", "", "archive `format`: tar, tar.zst or zip (default: from the extension of the -o file)")
+	output := jsonOutput(fs)
+	return func(ctx context.Context, args []string) error {
+		if *archive != "" || *format != "" {
+			if len(args) != 1 || *archive == "" {
+				return usageError("expected DOMAIN[@ID] -o FILE")
+			}
+			if opts.Delete || opts.Force || opts.DryRun || opts.InPlace {
+				return usageError("--delete, --force, --dry-run and --in-place only apply to directories")
+			}
+		} else if len(args) != 2 {
+			return usageError("expected DOMAIN[@ID] DIRECTORY")
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		report := newReporter(output())
+		opts.Connection = e.connection(e.url, e.key, report.emit)
+		opts.Team = *team
+		opts.Domain, opts.ID = splitDomainID(args[0])
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		if *archive != "" {
+			if *archive == "-" && output() != nil {
+				return usageError("--json cannot be combined with -o -")
+			}
+			return downloadArchive(ctx, *archive, client.ArchiveOptions{
+				Connection:  opts.Connection,
+				Domain:      opts.Domain,
+				ID:          opts.ID,
+				Team:        opts.Team,
+				Format:      client.ArchiveFormat(cmp.Or(*format, archiveFormat(*archive))),
+				Parallelism: opts.Parallelism,
+			})
+		}
+		opts.Destination = args[1]
+		if _, err := client.Fetch(ctx, opts); err != nil {
+			report.emit(client.Event{Type: client.EventError, Message: err.Error()})
+			return fmt.Errorf("failed to download: %w", err)
+		}
+		return nil
+	}
+}
+
+// archiveFormat guesses the format of an archive from its file name
+func archiveFormat(name string) string {
+	for _, f := range client.ArchiveFormats {
+		if strings.HasSuffix(name, "."+string(f)) {
+			return string(f)
+		}
+	}
+	if strings.HasSuffix(name, ".tzst") {
+		return string(client.FormatTarZstd)
+	}
+	return ""
+}
+
+// downloadArchive writes an upload as an archive to name, or stdout for "-"
+func downloadArchive(ctx context.Context, name string, opts client.ArchiveOptions) error {
+	if opts.Format == "" {
+		return usageError("cannot tell the archive format from " + name + ", use --format")
+	}
+	fail := func(err error) error {
+		opts.OnEvent(client.Event{Type: client.EventError, Message: err.Error()})
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	if name == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		if _, err := client.Archive(ctx, w, opts); err != nil {
+			return fail(err)
+		}
+		return w.Flush()
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, err = client.Archive(ctx, w, opts)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+		return fail(err)
+	}
+	return nil
+}
+
+func teamsCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	asJSON := fs.Bool("json", false, "print the teams as JSON on stdout")
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return usageError("expected no arguments")
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		list, err := client.Teams(ctx, client.TeamsOptions{Connection: e.connection(e.url, e.key, newReporter(nil).emit)})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return json.NewEncoder(os.Stdout).Encode(struct {
+				Teams         []teamJSON `json:"teams"`
+				ManagementURL string     `json:"managementUrl,omitempty"`
+			}{teamsJSON(list.Teams), list.ManagementURL})
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME")
+		for _, t := range list.Teams {
+			fmt.Fprintf(w, "%s\t%s\n", t.ID, t.Name)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if list.ManagementURL != "" {
+			log.Printf("👥 Teams can be managed at %s", list.ManagementURL)
+		}
+		return nil
+	}
+}
+
+// teamJSON is the JSON form of a team
+type teamJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func teamsJSON(teams []protocol.Team) []teamJSON {
+	out := []teamJSON{}
+	for _, t := range teams {
+		out = append(out, teamJSON{t.ID, t.Name})
+	}
+	return out
+}
+
+func whoamiCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	asJSON := fs.Bool("json", false, "print the key description as JSON on stdout")
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return usageError("expected no arguments")
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		id, err := client.Whoami(ctx, client.TeamsOptions{Connection: e.connection(e.url, e.key, newReporter(nil).emit)})
+		if err != nil {
+			return fmt.Errorf("key verification failed: %w", err)
+		}
+		log.Print("✅ Key is valid")
+		if !id.Described {
+			log.Print("⚠️ The server does not describe keys, only their teams")
+		}
+		if *asJSON {
+			out := struct {
+				Profile       string     `json:"profile"`
+				User          string     `json:"user,omitempty"`
+				Team          *teamJSON  `json:"team,omitempty"`
+				Domains       []string   `json:"domains,omitempty"`
+				Teams         []teamJSON `json:"teams"`
+				ManagementURL string     `json:"managementUrl,omitempty"`
+			}{Profile: e.profile, User: id.User, Domains: id.Domains, Teams: teamsJSON(id.Teams), ManagementURL: id.ManagementURL}
+			if id.Team != nil {
+				out.Team = &teamJSON{id.Team.ID, id.Team.Name}
+			}
+			return json.NewEncoder(os.Stdout).Encode(out)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "profile\t%s\n", e.profile)
+		if id.User != "" {
+			fmt.Fprintf(w, "user\t%s\n", id.User)
+		}
+		if id.Team != nil {
+			fmt.Fprintf(w, "team\t%s (%s)\n", id.Team.Name, id.Team.ID)
+		}
+		if id.Described {
+			fmt.Fprintf(w, "domains\t%s\n", cmp.Or(strings.Join(id.Domains, ", "), "none"))
+		}
+		var teams []string
+		for _, t := range id.Teams {
+			teams = append(teams, fmt.Sprintf("%s (%s)", t.Name, t.ID))
+		}
+		fmt.Fprintf(w, "teams\t%s\n", cmp.Or(strings.Join(teams, ", "), "none"))
+		return w.Flush()
+	}
+}
+
+func historyCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	opts := client.HistoryOptions{}
+	team := addTeamFlag(fs, keyTeam)
+	fs.IntVar(&opts.Limit, "limit", 20, "most recent uploads to list, 0 for the server's default")
+	asJSON := fs.Bool("json", false, "print the uploads as JSON on stdout")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return usageError("expected DOMAIN")
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		opts.Connection = e.connection(e.url, e.key, newReporter(nil).emit)
+		opts.Team = *team
+		opts.Domain = args[0]
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		uploads, err := client.History(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			type uploadJSON struct {
+				ID       string    `json:"id"`
+				Bundle   string    `json:"bundle"`
+				Time     time.Time `json:"time"`
+				Uploader string    `json:"uploader,omitempty"`
+				Files    int       `json:"files"`
+				Size     int64     `json:"size"`
+				Live     bool      `json:"live"`
+			}
+			out := []uploadJSON{}
+			for _, u := range uploads {
+				out = append(out, uploadJSON{u.ID, fmt.Sprintf("%x", u.Bundle), u.Time, u.Uploader, u.Files, u.Size, u.Live})
+			}
+			return json.NewEncoder(os.Stdout).Encode(out)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "\tID\tBUNDLE\tTIME\tUPLOADER\tFILES\tSIZE")
+		for _, u := range uploads {
+			marker := ""
+			if u.Live {
+				marker = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%x\t%s\t%s\t%d\t%d\n", marker, u.ID, u.Bundle[:6], u.Time.Local().Format(time.DateTime), u.Uploader, u.Files, u.Size)
+		}
+		return w.Flush()
+	}
+}
+
+// promoteCommand makes an earlier upload live again: the one named by DOMAIN@ID, or when
+// rolling back, the one preceding the live upload of DOMAIN
+func promoteCommand(rollback bool) func(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+		e := addEndpointFlags(fs)
+		opts := client.PromoteOptions{}
+		team := addTeamFlag(fs, keyTeam)
+		output := jsonOutput(fs)
+		return func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				if rollback {
+					return usageError("expected DOMAIN")
+				}
+				return usageError("expected DOMAIN@ID")
+			}
+			opts.Domain, opts.ID = splitDomainID(args[0])
+			if rollback && opts.ID != "" {
+				return usageError("rollback takes no upload ID; use 'xmit promote DOMAIN@ID'")
+			}
+			if !rollback && opts.ID == "" {
+				return usageError("expected DOMAIN@ID (see 'xmit history DOMAIN')")
+			}
+			if err := e.resolve(); err != nil {
+				return err
+			}
+			report := newReporter(output())
+			opts.Connection = e.connection(e.url, e.key, report.emit)
+			opts.Team = *team
+			ctx, cancel := e.withTimeout(ctx)
+			defer cancel()
+			if _, err := client.Promote(ctx, opts); err != nil {
+				report.emit(client.Event{Type: client.EventError, Message: err.Error()})
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// changeMarks are the git-style letters marking changes
+var changeMarks = map[client.ChangeKind]string{
+	client.Added:    "A",
+	client.Removed:  "D",
+	client.Modified: "M",
+}
+
+func diffCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	e := addEndpointFlags(fs)
+	opts := client.DiffOptions{CachePath: hashCachePath}
+	team := addTeamFlag(fs, projectTeam)
+	fs.IntVar(&opts.HashParallelism, "hash-parallelism", envInt("HASH_PARALLELISM", runtime.GOMAXPROCS(0)), "concurrent file hashing workers (env HASH_PARALLELISM)")
+	fs.BoolVar(&opts.Rehash, "rehash", envBool("XMIT_REHASH"), "ignore the local hash cache and rehash every file (env XMIT_REHASH)")
+	fs.BoolVar(&opts.Content, "content", false, "download changed text files (HTML, CSS, JS…) and show their unified diffs")
+	fs.IntVar(&opts.Parallelism, "parallelism", envInt("DOWNLOAD_PARALLELISM", 3), "concurrent part downloads (env DOWNLOAD_PARALLELISM)")
+	output := jsonOutput(fs)
+	return func(ctx context.Context, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return usageError("expected DOMAIN[@ID] [DIRECTORY], or DOMAIN@ID DOMAIN@ID")
+		}
+		if err := e.resolve(); err != nil {
+			return err
+		}
+		w := output()
+		report := newReporter(w)
+		ctx, cancel := e.withTimeout(ctx)
+		defer cancel()
+
+		var result *client.DiffResult
+		var err error
+		if len(args) == 2 && isUploadRef(args[1]) {
+			uploads := client.DiffUploadsOptions{
+				Connection:  e.connection(e.url, e.key, report.emit),
+				Team:        *team,
+				Content:     opts.Content,
+				Parallelism: opts.Parallelism,
+			}
+			uploads.Old.Domain, uploads.Old.ID = splitDomainID(args[0])
+			uploads.New.Domain, uploads.New.ID = splitDomainID(args[1])
+			result, err = client.DiffUploads(ctx, uploads)
 		} else {
-			fmt.Println("API keys are provisioned for users or teams after logging into https://xmit.co/admin\nUser keys are best on your personal machines, team keys for CI/CD systems.\n🔑 Enter your API key (no echo):")
-			keyBytes, err := term.ReadPassword(int(syscall.Stdin))
+			opts.Directory, err = findDirectory(args[1:])
 			if err != nil {
-				log.Fatalf("🛑 Failed to read API key: %v", err)
+				return err
 			}
-			key = string(keyBytes)
+			opts.Connection = e.connection(e.url, e.key, report.emit)
+			opts.Team = *team
+			opts.Domain, opts.ID = splitDomainID(args[0])
+			result, err = client.Diff(ctx, opts)
 		}
-		if err := storeKey(key); err != nil {
-			log.Fatalf("🛑 Failed to store API key: %v", err)
+		if err != nil {
+			report.emit(client.Event{Type: client.EventError, Message: err.Error()})
+			return err
 		}
-		os.Exit(0)
+		if w == nil {
+			for _, c := range result.Changes {
+				fmt.Printf("%s %s\n", changeMarks[c.Kind], c.Path)
+			}
+			for _, c := range result.Changes {
+				fmt.Print(c.Diff)
+			}
+		}
+		return nil
+	}
+}
+
+// isUploadRef tells whether a diff argument designates an upload (DOMAIN@ID) rather than a directory
+func isUploadRef(arg string) bool {
+	if !strings.Contains(arg, "@") {
+		return false
 	}
+	_, err := os.Stat(arg)
+	return err != nil
+}
 
-	if command == "preview" {
-		if err := preview.Serve(findDirectory()); err != nil {
-			log.Fatalf("🛑 Failed to preview: %v", err)
+func previewCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	listen := fs.String("listen", envString("LISTEN", ":4000"), "`address` to listen on (env LISTEN)")
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 1 {
+			return usageError("expected at most one DIRECTORY")
+		}
+		directory, err := findDirectory(args)
+		if err != nil {
+			return err
 		}
-		return
+		if err := preview.Serve(ctx, directory, *listen); err != nil {
+			return fmt.Errorf("failed to preview: %w", err)
+		}
+		return nil
 	}
+}
 
-	if command == "download" {
-		key := findKey()
+func loginCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	opts := client.LoginOptions{}
+	var name, url string
+	addProfileFlag(fs, &name)
+	fs.StringVar(&url, "url", "", "service `URL`, remembered by the profile (env XMIT_URL, default: the profile's URL or "+protocol.DefaultURL+")")
+	hostname, _ := os.Hostname()
+	fs.StringVar(&opts.Name, "name", strings.TrimSpace("xmit CLI "+hostname), "`label` of the requested key")
+	store := addStoreFlag(fs)
+	noBrowser := fs.Bool("no-browser", false, "only print the approval URL instead of opening it")
+	timeout := fs.Duration("timeout", envDuration("XMIT_TIMEOUT", 10*time.Minute), "deadline for the approval, 0 for none (env XMIT_TIMEOUT)")
+	requests := addRequestFlags(fs)
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return usageError("expected no arguments")
+		}
+		name = selectedProfile(name)
+		if err := checkProfileName(name); err != nil {
+			return err
+		}
+		profiles, err := loadProfiles()
+		if err != nil {
+			return err
+		}
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		report := newReporter(nil)
+		opts.Connection = requests.connection(serviceURL(url, profiles[name]), "", func(e client.Event) {
+			report.emit(e)
+			if e.Type == client.EventApproval && !*noBrowser {
+				if err := openBrowser(e.URL); err != nil {
+					log.Printf("⚠️ Failed to open browser: %v", err)
+				}
+			}
+		})
+		key, err := client.Login(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to log in: %w", err)
+		}
+		if err := storeKey(name, key, url, *store); err != nil {
+			return fmt.Errorf("failed to store API key: %w", err)
+		}
+		log.Printf("🔑 API key stored for profile %s", name)
+		return nil
+	}
+}
+
+func setKeyCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	var name string
+	addProfileFlag(fs, &name)
+	url := fs.String("url", "", "service `URL` to remember for the profile")
+	store := addStoreFlag(fs)
+	return func(_ context.Context, args []string) error {
+		if len(args) > 1 {
+			return usageError("expected at most one KEY")
+		}
+		name = selectedProfile(name)
+		if err := checkProfileName(name); err != nil {
+			return err
+		}
+		var key string
+		if len(args) > 0 && args[0] == "-" {
+			keyBytes, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read API key: %w", err)
+			}
+			key = string(keyBytes)
+		} else if len(args) > 0 {
+			key = args[0]
+		} else {
+			// Let an interrupt kill the prompt, which cannot be aborted otherwise
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			fmt.Println("API keys are provisioned for users or teams after logging into https://xmit.co/admin\nUser keys are best on your personal machines, team keys for CI/CD systems.\n🔑 Enter your API key (no echo):")
+			keyBytes, err := term.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				return fmt.Errorf("failed to read API key: %w", err)
+			}
+			key = string(keyBytes)
+		}
+		key = strings.TrimSpace(key)
 		if key == "" {
-			log.Fatalf("🛑 No key found. Set XMIT_KEY or run 'xmit set-key'.")
+			return errors.New("empty API key")
 		}
-		if len(os.Args) < 4 {
-			log.Fatalf("🛑 Missing domain[@id] destination arguments")
+		if err := storeKey(name, key, *url, *store); err != nil {
+			return fmt.Errorf("failed to store API key: %w", err)
 		}
-		domainAndID := os.Args[2]
-		parts := strings.SplitN(domainAndID, "@", 2)
-		domain := parts[0]
-		id := ""
-		if len(parts) == 2 {
-			id = parts[1]
+		return nil
+	}
+}
+
+func profilesCommand(*flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(_ context.Context, args []string) error {
+		action := "list"
+		if len(args) > 0 {
+			action = args[0]
 		}
-		destination := os.Args[3]
-		if err := download(key, domain, id, destination); err != nil {
-			log.Fatalf("🛑 Failed to download: %v", err)
+		switch {
+		case action == "list" && len(args) <= 1:
+			profiles, err := loadProfiles()
+			if err != nil {
+				return err
+			}
+			selected := selectedProfile("")
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "\tNAME\tURL\tSTORE")
+			for _, name := range profileNames(profiles) {
+				marker := ""
+				if name == selected {
+					marker = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", marker, name, cmp.Or(profiles[name].URL, protocol.DefaultURL), cmp.Or(profiles[name].Store, fileStoreName))
+			}
+			return w.Flush()
+		case action == "remove" && len(args) == 2:
+			if err := removeProfile(args[1]); err != nil {
+				return fmt.Errorf("failed to remove profile: %w", err)
+			}
+			log.Printf("🗑️ Removed profile %s", args[1])
+			return nil
 		}
-		return
+		return usageError("expected list, or remove NAME")
 	}
+}
 
-	// Default: upload to domain
-	domain := command
-	key := findKey()
-	directory := findDirectory()
-	upload(key, domain, directory)
+func helpCommand(*flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(_ context.Context, args []string) error {
+		if len(args) == 0 {
+			usage()
+			return nil
+		}
+		c := findCommand(args[0])
+		if c == nil {
+			return usageError(fmt.Sprintf("unknown command %q", args[0]))
+		}
+		run(c, []string{"--help"})
+		return nil
+	}
 }
@@ -1,6 +1,8 @@
 package preview
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,7 +17,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pelletier/go-toml/v2"
-	"github.com/titanous/json5"
 	"github.com/xmit-co/xmit/config"
 )
 
@@ -33,25 +34,9 @@ func openFile(path string) *os.File {
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	cfg := config.XmitConfig{}
-	jsonPath := filepath.Join(h.directory, "xmit.json")
-	tomlPath := filepath.Join(h.directory, "xmit.toml")
-	cfgBytes, err := os.ReadFile(jsonPath)
-	if err == nil {
-		err = json5.Unmarshal(cfgBytes, &cfg)
-		if err != nil {
-			log.Printf("⚠️ %s: %v", jsonPath, err)
-		}
-	} else if os.IsNotExist(err) {
-		cfgBytes, err = os.ReadFile(tomlPath)
-		if err == nil {
-			err = toml.Unmarshal(cfgBytes, &cfg)
-		}
-		if err != nil && !os.IsNotExist(err) {
-			log.Printf("⚠️ %s: %v", tomlPath, err)
-		}
-	} else {
-		log.Printf("⚠️ %s: %v", jsonPath, err)
+	cfg, err := config.Load(h.directory)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
 	}
 	w.Header().Add("Server", "xmit")
 	w.Header().Add("X-Frame-Options", "SAMEORIGIN")
@@ -233,20 +218,26 @@ func sendFormByMail(r *http.Request, to string) error {
 	return nil
 }
 
-func Serve(directory string) error {
-	listen := os.Getenv("LISTEN")
+// Serve serves directory on listen until ctx is done
+func Serve(ctx context.Context, directory, listen string) error {
 	if listen == "" {
-		listen = ":4000"
-		log.Printf("Listening on %s (set LISTEN to override)", listen)
-	} else {
-		log.Printf("Listening on %s", listen)
+		return errors.New("no address to listen on")
 	}
+	log.Printf("Listening on %s", listen)
 	serveAddr := listen
-	if serveAddr[0] == ':' {
+	if strings.HasPrefix(serveAddr, ":") {
 		serveAddr = "localhost" + serveAddr
 	}
 	log.Printf("Preview of %s: http://%s", directory, serveAddr)
-	return http.ListenAndServe(listen, &handler{directory})
+	server := &http.Server{Addr: listen, Handler: &handler{directory}}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 func internalError(w http.ResponseWriter, err error) {
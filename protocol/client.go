@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// RetryPolicy controls how requests failing transiently are retried
+type RetryPolicy struct {
+	Retries  int           // retries after the first attempt
+	MinDelay time.Duration // delay before the first retry, doubled for each further one
+	MaxDelay time.Duration // upper bound on the delay between attempts
+}
+
+// DefaultRetryPolicy is used by new clients
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:  4,
+	MinDelay: 500 * time.Millisecond,
+	MaxDelay: 30 * time.Second,
+}
+
+// delay returns the jittered exponential backoff before retry number n (starting at 1)
+func (r RetryPolicy) delay(n int, err error) time.Duration {
+	d := r.MinDelay
+	for i := 1; i < n && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, r.MaxDelay)
+	if d > 1 {
+		d = d/2 + rand.N(d/2)
+	}
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > d {
+		d = min(se.RetryAfter, r.MaxDelay)
+	}
+	return d
+}
+
+// StatusError reports an unexpected HTTP status from an endpoint
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d from %s", e.StatusCode, e.Endpoint)
+}
+
+// Temporary reports whether the status is worth retrying (throttling or server errors)
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IntegrityError reports parts whose received contents kept not matching their hash
+type IntegrityError struct {
+	Hashes []Hash
+}
+
+func (e *IntegrityError) Error() string {
+	if len(e.Hashes) == 1 {
+		return fmt.Sprintf("part %x failed verification", e.Hashes[0])
+	}
+	return fmt.Sprintf("%d parts failed verification, including %x", len(e.Hashes), e.Hashes[0])
+}
+
+// temporaryError marks network failures, which are retried
+type temporaryError struct {
+	err error
+}
+
+func (e *temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *temporaryError) Unwrap() error {
+	return e.err
+}
+
+// Temporary reports whether err is a transient failure: a network error or a 429/5xx status
+func Temporary(err error) bool {
+	return retryable(err)
+}
+
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	var te *temporaryError
+	return errors.As(err, &te)
+}
+
+// CallPolicy controls how requests are attempted
+type CallPolicy struct {
+	// Retry is the policy applied to every request
+	Retry RetryPolicy
+	// RequestTimeout bounds each attempt of a request, including reading its response (0 for none)
+	RequestTimeout time.Duration
+}
+
+// DefaultRequestTimeout bounds attempts of new clients, so a stalled server cannot block them forever
+const DefaultRequestTimeout = 10 * time.Minute
+
+// DefaultCallPolicy retries following DefaultRetryPolicy, bounding attempts by DefaultRequestTimeout
+var DefaultCallPolicy = CallPolicy{Retry: DefaultRetryPolicy, RequestTimeout: DefaultRequestTimeout}
+
+// Client sends requests to an xmit endpoint, spreading them across its resolved IPs
+// and failing over to the next IP when retrying
+type Client struct {
+	CallPolicy
+
+	clients   []*http.Client
+	baseURL   string
+	encMode   cbor.EncMode
+	clientIdx atomic.Uint64
+}
+
+// NewClient creates a client for baseURL
+func NewClient(ctx context.Context, baseURL string) (*Client, error) {
+	clients, err := resolveClients(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	encMode, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cbor encoder: %w", err)
+	}
+
+	return &Client{
+		CallPolicy: DefaultCallPolicy,
+		clients:    clients,
+		baseURL:    baseURL,
+		encMode:    encMode,
+	}, nil
+}
+
+// EncMode returns the CBOR encoding mode
+func (c *Client) EncMode() cbor.EncMode {
+	return c.encMode
+}
+
+// nextClient selects a client in round-robin fashion, returning its index
+func (c *Client) nextClient() (int, *http.Client) {
+	clientIdx := int(c.clientIdx.Add(1)-1) % len(c.clients)
+	return clientIdx, c.clients[clientIdx]
+}
+
+// retry calls attempt until it succeeds, fails permanently, exhausts the retry policy or ctx is done.
+// Each attempt gets its own context, bounded by RequestTimeout.
+func (p CallPolicy) retry(ctx context.Context, what string, attempt func(ctx context.Context) error) error {
+	for n := 1; ; n++ {
+		err := p.attempt(ctx, attempt)
+		if err == nil || ctx.Err() != nil || n > p.Retry.Retries || !retryable(err) {
+			return err
+		}
+		d := p.Retry.delay(n, err)
+		log.Printf("🔁 %s failed (%v), retry %d/%d in %v…", what, err, n, p.Retry.Retries, d.Round(time.Millisecond))
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p CallPolicy) attempt(ctx context.Context, attempt func(ctx context.Context) error) error {
+	if p.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.RequestTimeout)
+		defer cancel()
+	}
+	return attempt(ctx)
+}
+
+// send posts payload to endpoint, reading it from body (which defaults to the payload itself)
+func (c *Client) send(ctx context.Context, client *http.Client, endpoint string, payload []byte, body io.Reader) (*http.Response, error) {
+	if body == nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/cbor+zstd")
+	req.ContentLength = int64(len(payload))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(payload)), nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &temporaryError{fmt.Errorf("failed to post to %s: %w", endpoint, err)}
+	}
+
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		se := &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode}
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			se.RetryAfter = time.Duration(s) * time.Second
+		}
+		return nil, se
+	}
+	return resp, nil
+}
+
+// receive decodes a response; failures are assumed to come from a broken connection
+func receive(resp *http.Response, r any) error {
+	if err := decodeResponse(resp.Body, r); err != nil {
+		return &temporaryError{err}
+	}
+	return nil
+}
+
+// call encodes req, posts it to endpoint with retries and decodes the response into r
+func (c *Client) call(ctx context.Context, endpoint string, req, r any) error {
+	payload, err := encodeRequest(c.encMode, req)
+	if err != nil {
+		return err
+	}
+	return c.retry(ctx, endpoint, func(ctx context.Context) error {
+		_, client := c.nextClient()
+		resp, err := c.send(ctx, client, endpoint, payload, nil)
+		if err != nil {
+			return err
+		}
+		return receive(resp, r)
+	})
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// postDirect sends a request to target without IP spreading, following policy
+func postDirect(ctx context.Context, policy CallPolicy, target string, req, r any) error {
+	encMode, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return fmt.Errorf("failed to create cbor encoder: %w", err)
+	}
+	payload, err := encodeRequest(encMode, req)
+	if err != nil {
+		return err
+	}
+	return policy.retry(ctx, target, func(ctx context.Context) error {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/cbor+zstd")
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return &temporaryError{fmt.Errorf("failed to post to %s: %w", target, err)}
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return &StatusError{Endpoint: target, StatusCode: resp.StatusCode}
+		}
+		return receive(resp, r)
+	})
+}
+
+// RequestKey starts a browser-based key request against the endpoint at baseURL
+func RequestKey(ctx context.Context, baseURL, name string, policy CallPolicy) (*RequestKeyResponse, error) {
+	var r RequestKeyResponse
+	if err := postDirect(ctx, policy, baseURL+requestKeyEndpoint, &RequestKeyRequest{Name: name}, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// PollKey checks whether a key request was approved; pollURL may be relative to baseURL
+func PollKey(ctx context.Context, baseURL, pollURL string, req PollKeyRequest, policy CallPolicy) (*PollKeyResponse, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL: %w", err)
+	}
+	ref, err := url.Parse(pollURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse poll URL: %w", err)
+	}
+	var r PollKeyResponse
+	if err := postDirect(ctx, policy, base.ResolveReference(ref).String(), &req, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
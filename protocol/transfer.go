@@ -11,13 +11,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/klauspost/compress/zstd"
+	"github.com/zeebo/blake3"
 )
 
 const (
@@ -29,6 +29,9 @@ const (
 	bundleDownloadEndpoint = endpointPrefix + "/dl/bundle"
 	partsDownloadEndpoint  = endpointPrefix + "/dl/parts"
 	listTeamsEndpoint      = endpointPrefix + "/teams"
+	requestKeyEndpoint     = endpointPrefix + "/key"
+	keyInfoEndpoint        = endpointPrefix + "/whoami"
+	listUploadsEndpoint    = endpointPrefix + "/uploads"
 )
 
 // DiscoveryInfo holds the response from /.well-known/web-publication-protocol
@@ -38,26 +41,38 @@ type DiscoveryInfo struct {
 	APIKeyManagementURL string   `json:"apiKeyManagementUrl"`
 }
 
-// Discover fetches the xmit discovery info from XMIT_URL (default: https://xmit.co)
-func Discover() (*DiscoveryInfo, error) {
-	baseURL := os.Getenv("XMIT_URL")
+// DefaultURL is the base URL of the public xmit service
+const DefaultURL = "https://xmit.co"
+
+// Discover fetches the xmit discovery info from baseURL (default: DefaultURL)
+func Discover(ctx context.Context, baseURL string, policy CallPolicy) (*DiscoveryInfo, error) {
 	if baseURL == "" {
-		baseURL = "https://xmit.co"
+		baseURL = DefaultURL
 	}
 	discoveryURL := baseURL + "/.well-known/web-publication-protocol"
-	resp, err := http.Get(discoveryURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch discovery info from %s: %w", discoveryURL, err)
-	}
-	defer resp.Body.Close()
+	var info DiscoveryInfo
+	if err := policy.retry(ctx, "discovery", func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return &temporaryError{fmt.Errorf("failed to fetch discovery info from %s: %w", discoveryURL, err)}
+		}
+		defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("discovery endpoint returned status %d", resp.StatusCode)
-	}
+		if resp.StatusCode != 200 {
+			return &StatusError{Endpoint: discoveryURL, StatusCode: resp.StatusCode}
+		}
 
-	var info DiscoveryInfo
-	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
-		return nil, fmt.Errorf("failed to decode discovery info: %w", err)
+		info = DiscoveryInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return fmt.Errorf("failed to decode discovery info: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	// Validate that xmit/0 protocol is supported
@@ -164,6 +179,39 @@ type ListTeamsResponse struct {
 	ManagementURL string `cbor:"6,keyasint,omitempty"`
 }
 
+type KeyInfoRequest struct {
+	Request
+}
+
+// KeyInfoResponse describes the owner of a key and what it may publish
+type KeyInfoResponse struct {
+	Response
+	User    string   `cbor:"5,keyasint,omitempty"` // owning user, for user keys
+	Team    *Team    `cbor:"6,keyasint,omitempty"` // owning team, for team keys
+	Domains []string `cbor:"7,keyasint,omitempty"` // domains the key may publish to
+}
+
+type ListUploadsRequest struct {
+	Request
+	Limit int `cbor:"6,keyasint,omitempty"` // most recent uploads to list, 0 for the server's default
+}
+
+// Upload describes a past upload of a domain
+type Upload struct {
+	ID       string    `cbor:"1,keyasint,omitempty"`
+	Bundle   Hash      `cbor:"2,keyasint,omitempty"`
+	Time     time.Time `cbor:"3,keyasint,omitempty"`
+	Uploader string    `cbor:"4,keyasint,omitempty"`
+	Files    int       `cbor:"5,keyasint,omitempty"`
+	Size     int64     `cbor:"6,keyasint,omitempty"`
+	Live     bool      `cbor:"7,keyasint,omitempty"`
+}
+
+type ListUploadsResponse struct {
+	Response
+	Uploads []Upload `cbor:"5,keyasint,omitempty"` // most recent first
+}
+
 type RequestKeyRequest struct {
 	Name string `cbor:"1,keyasint,omitempty"`
 }
@@ -176,8 +224,20 @@ type RequestKeyResponse struct {
 	RequestID  string `cbor:"8,keyasint,omitempty"`
 }
 
+// PollKeyRequest asks whether a key request was approved
+type PollKeyRequest struct {
+	RequestID string `cbor:"1,keyasint,omitempty"`
+	Secret    string `cbor:"2,keyasint,omitempty"`
+}
+
+type PollKeyResponse struct {
+	Response
+	Key     string `cbor:"5,keyasint,omitempty"`
+	Pending bool   `cbor:"6,keyasint,omitempty"`
+}
+
 // resolveClients resolves a URL to multiple IPs and creates an HTTP client for each
-func resolveClients(baseURL string) ([]*http.Client, error) {
+func resolveClients(ctx context.Context, baseURL string) ([]*http.Client, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
@@ -193,10 +253,14 @@ func resolveClients(baseURL string) ([]*http.Client, error) {
 		}
 	}
 
-	ips, err := net.LookupIP(host)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
 	if err != nil {
 		return nil, fmt.Errorf("failed to lookup IPs for %s: %w", host, err)
 	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
 
 	// Filter to IPv4 addresses only for simplicity
 	var ipv4s []net.IP
@@ -273,40 +337,47 @@ func decodeResponse(body io.ReadCloser, resp interface{}) error {
 	return nil
 }
 
+// ChunkStage is a step of a chunk upload
+type ChunkStage string
+
+const (
+	ChunkSending ChunkStage = "sending"
+	ChunkSent    ChunkStage = "sent"
+	ChunkDone    ChunkStage = "done"
+)
+
+// ChunkProgress describes a chunk reaching a stage of its upload
+type ChunkProgress struct {
+	Index int
+	Count int
+	Parts int
+	Bytes int // compressed payload size
+	Stage ChunkStage
+}
+
 // ParallelUploader manages parallel chunk uploads across multiple IPs
 type ParallelUploader struct {
-	clients   []*http.Client
-	baseURL   string
-	encMode   cbor.EncMode
-	sendSem   chan struct{}
-	clientIdx atomic.Uint64
+	*Client
+
+	// Progress, if set, is called from upload goroutines as chunks progress
+	Progress func(ChunkProgress)
+
+	sendSem chan struct{}
 }
 
 // NewParallelUploader creates an uploader that spreads requests across IPs
-func NewParallelUploader(baseURL string, concurrency int) (*ParallelUploader, error) {
-	clients, err := resolveClients(baseURL)
+func NewParallelUploader(ctx context.Context, baseURL string, concurrency int) (*ParallelUploader, error) {
+	client, err := NewClient(ctx, baseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	encMode, err := cbor.CanonicalEncOptions().EncMode()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cbor encoder: %w", err)
-	}
-
 	return &ParallelUploader{
-		clients: clients,
-		baseURL: baseURL,
-		encMode: encMode,
+		Client:  client,
 		sendSem: make(chan struct{}, concurrency),
 	}, nil
 }
 
-// EncMode returns the CBOR encoding mode
-func (p *ParallelUploader) EncMode() cbor.EncMode {
-	return p.encMode
-}
-
 // ChunkUploadResult holds the result of a single chunk upload
 type ChunkUploadResult struct {
 	Index    int
@@ -314,30 +385,43 @@ type ChunkUploadResult struct {
 	Err      error
 }
 
-// UploadChunksParallel uploads all chunks in parallel (max concurrency), starting in order
-func (p *ParallelUploader) UploadChunksParallel(key, domain string, chunks [][][]byte) []ChunkUploadResult {
-	results := make([]ChunkUploadResult, len(chunks))
+// ChunkLoader returns the parts of chunk i; it is called when the chunk is about to be encoded
+type ChunkLoader func(i int) ([][]byte, error)
+
+// UploadChunksParallel uploads count chunks in parallel (max concurrency), starting in order.
+// Chunks are loaded lazily so that only the ones in flight are held in memory.
+// Once ctx is done, chunks not yet started fail with its error and in-flight ones are aborted.
+func (p *ParallelUploader) UploadChunksParallel(ctx context.Context, req Request, count int, load ChunkLoader) []ChunkUploadResult {
+	results := make([]ChunkUploadResult, count)
+	if count == 0 {
+		return results
+	}
 	var wg sync.WaitGroup
 
 	// Use a channel to ensure chunks start in order
-	starts := make([]chan struct{}, len(chunks))
+	starts := make([]chan struct{}, count)
 	for i := range starts {
 		starts[i] = make(chan struct{})
 	}
 
-	for i, chunk := range chunks {
+	for i := range count {
 		wg.Add(1)
-		go func(idx int, parts [][]byte) {
+		go func(idx int) {
 			defer wg.Done()
 			// Wait for our turn to start
-			<-starts[idx]
-			resp, err := p.uploadChunk(key, domain, idx, len(chunks), parts, starts)
+			select {
+			case <-starts[idx]:
+			case <-ctx.Done():
+				results[idx] = ChunkUploadResult{Index: idx, Err: ctx.Err()}
+				return
+			}
+			resp, err := p.uploadChunk(ctx, req, idx, count, load, starts)
 			results[idx] = ChunkUploadResult{
 				Index:    idx,
 				Response: resp,
 				Err:      err,
 			}
-		}(i, chunk)
+		}(i)
 	}
 
 	// Signal first chunk to start
@@ -347,205 +431,142 @@ func (p *ParallelUploader) UploadChunksParallel(key, domain string, chunks [][][
 	return results
 }
 
-func (p *ParallelUploader) uploadChunk(key, domain string, i, count int, parts [][]byte, starts []chan struct{}) (*MissingUploadResponse, error) {
-	// Encode the request
-	payload, err := encodeRequest(p.encMode, &MissingUploadRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		Parts: parts,
-	})
-	if err != nil {
-		// Signal next chunk to start even on error
-		if i+1 < len(starts) {
+func (p *ParallelUploader) uploadChunk(ctx context.Context, req Request, i, count int, load ChunkLoader, starts []chan struct{}) (*MissingUploadResponse, error) {
+	// Signal next chunk to start, once
+	var signaled bool
+	signalNext := func() {
+		if !signaled && i+1 < len(starts) {
 			close(starts[i+1])
 		}
-		return nil, err
+		signaled = true
 	}
 
-	// Acquire semaphore for sending data
-	p.sendSem <- struct{}{}
+	// Load and encode the request
+	parts, err := load(i)
+	var payload []byte
+	if err == nil {
+		payload, err = encodeRequest(p.encMode, &MissingUploadRequest{
+			Request: req,
+			Parts:   parts,
+		})
+	}
+	if err != nil {
+		// Signal next chunk to start even on error
+		signalNext()
+		return nil, err
+	}
 
-	// Signal next chunk to start (after we acquired semaphore)
-	if i+1 < len(starts) {
-		close(starts[i+1])
+	progress := func(stage ChunkStage) {
+		if p.Progress != nil {
+			p.Progress(ChunkProgress{Index: i, Count: count, Parts: len(parts), Bytes: len(payload), Stage: stage})
+		}
 	}
 
-	// Select client in round-robin fashion (after acquiring semaphore to spread load)
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
+	var r MissingUploadResponse
+	err = p.retry(ctx, fmt.Sprintf("Chunk %d/%d", i+1, count), func(ctx context.Context) error {
+		// Acquire semaphore for sending data
+		select {
+		case p.sendSem <- struct{}{}:
+		case <-ctx.Done():
+			signalNext()
+			return ctx.Err()
+		}
 
-	if len(parts) == 1 {
-		log.Printf("🏃 Uploading chunk %d/%d of 1 missing part (%d bytes compressed) via IP #%d…", i+1, count, len(payload), clientIdx+1)
-	} else {
-		log.Printf("🏃 Uploading chunk %d/%d of %d missing parts (%d bytes compressed) via IP #%d…", i+1, count, len(parts), len(payload), clientIdx+1)
-	}
+		// Signal next chunk to start (after we acquired semaphore)
+		signalNext()
 
-	// Create semaphore reader that releases semaphore when body is fully sent
-	bodyReader := &semaphoreReader{
-		reader: bytes.NewReader(payload),
-		sem:    p.sendSem,
-	}
+		// Select client in round-robin fashion (after acquiring semaphore to spread load)
+		clientIdx, client := p.nextClient()
 
-	// Create the request
-	req, err := http.NewRequest("POST", p.baseURL+missingUploadEndpoint, bodyReader)
-	if err != nil {
-		bodyReader.ensureReleased()
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
-	req.ContentLength = int64(len(payload))
-	req.GetBody = func() (io.ReadCloser, error) {
-		return io.NopCloser(bytes.NewReader(payload)), nil
-	}
+		if len(parts) == 1 {
+			log.Printf("🏃 Uploading chunk %d/%d of 1 missing part (%d bytes compressed) via IP #%d…", i+1, count, len(payload), clientIdx+1)
+		} else {
+			log.Printf("🏃 Uploading chunk %d/%d of %d missing parts (%d bytes compressed) via IP #%d…", i+1, count, len(parts), len(payload), clientIdx+1)
+		}
+		progress(ChunkSending)
 
-	resp, err := client.Do(req)
-	// Ensure semaphore is released if the reader didn't complete
-	bodyReader.ensureReleased()
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", missingUploadEndpoint, err)
-	}
+		// Create semaphore reader that releases semaphore when body is fully sent
+		bodyReader := &semaphoreReader{
+			reader: bytes.NewReader(payload),
+			sem:    p.sendSem,
+		}
 
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, missingUploadEndpoint)
-	}
+		resp, err := p.send(ctx, client, missingUploadEndpoint, payload, bodyReader)
+		// Ensure semaphore is released if the reader didn't complete
+		bodyReader.ensureReleased()
+		if err != nil {
+			return err
+		}
 
-	log.Printf("🧘 Chunk %d/%d upload complete, waiting for server…", i+1, count)
+		log.Printf("🧘 Chunk %d/%d upload complete, waiting for server…", i+1, count)
+		progress(ChunkSent)
 
-	var r MissingUploadResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+		return receive(resp, &r)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	log.Printf("✅ Chunk %d/%d done", i+1, count)
+	progress(ChunkDone)
 	return &r, nil
 }
 
 // UploadBundle uploads the bundle using a round-robin client
-func (p *ParallelUploader) UploadBundle(key, domain string, bundle []byte) (*BundleUploadResponse, error) {
+func (p *ParallelUploader) UploadBundle(ctx context.Context, req Request, bundle []byte) (*BundleUploadResponse, error) {
 	payload, err := encodeRequest(p.encMode, &BundleUploadRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		Bundle: bundle,
+		Request: req,
+		Bundle:  bundle,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Select client in round-robin fashion
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
-
-	log.Printf("🚶 Uploading bundle (%d bytes) via IP #%d…", len(payload), clientIdx+1)
+	var r BundleUploadResponse
+	err = p.retry(ctx, "Bundle upload", func(ctx context.Context) error {
+		// Select client in round-robin fashion
+		clientIdx, client := p.nextClient()
 
-	req, err := http.NewRequest("POST", p.baseURL+bundleUploadEndpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
+		log.Printf("🚶 Uploading bundle (%d bytes) via IP #%d…", len(payload), clientIdx+1)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", bundleUploadEndpoint, err)
-	}
-
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, bundleUploadEndpoint)
-	}
+		resp, err := p.send(ctx, client, bundleUploadEndpoint, payload, nil)
+		if err != nil {
+			return err
+		}
 
-	log.Print("🧘 Bundle upload complete, waiting for server…")
+		log.Print("🧘 Bundle upload complete, waiting for server…")
 
-	var r BundleUploadResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+		return receive(resp, &r)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &r, nil
 }
 
 // SuggestBundle suggests a bundle using a round-robin client
-func (p *ParallelUploader) SuggestBundle(key, domain string, id Hash) (*BundleSuggestResponse, error) {
-	payload, err := encodeRequest(p.encMode, &BundleSuggestRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		ID: id,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// Select client in round-robin fashion
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
-
+func (p *ParallelUploader) SuggestBundle(ctx context.Context, req Request, id Hash) (*BundleSuggestResponse, error) {
 	log.Print("🤔 Suggesting bundle…")
 
-	req, err := http.NewRequest("POST", p.baseURL+bundleSuggestEndpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", bundleSuggestEndpoint, err)
-	}
-
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, bundleSuggestEndpoint)
-	}
-
 	var r BundleSuggestResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+	if err := p.call(ctx, bundleSuggestEndpoint, &BundleSuggestRequest{
+		Request: req,
+		ID:      id,
+	}, &r); err != nil {
 		return nil, err
 	}
 	return &r, nil
 }
 
-// Finalize finalizes the upload using a round-robin client
-func (p *ParallelUploader) Finalize(key, domain string, id Hash) (*FinalizeUploadResponse, error) {
-	payload, err := encodeRequest(p.encMode, &FinalizeUploadRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		ID: id,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// Select client in round-robin fashion
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
-
+// Finalize makes the bundle id live, be it just uploaded or from an earlier upload
+func (c *Client) Finalize(ctx context.Context, req Request, id Hash) (*FinalizeUploadResponse, error) {
 	log.Print("🏁 Finalizing…")
 
-	req, err := http.NewRequest("POST", p.baseURL+finalizeUploadEndpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", finalizeUploadEndpoint, err)
-	}
-
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, finalizeUploadEndpoint)
-	}
-
 	var r FinalizeUploadResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+	if err := c.call(ctx, finalizeUploadEndpoint, &FinalizeUploadRequest{
+		Request: req,
+		ID:      id,
+	}, &r); err != nil {
 		return nil, err
 	}
 	return &r, nil
@@ -574,111 +595,120 @@ func (r *semaphoreReader) ensureReleased() {
 
 // ParallelDownloader manages parallel downloads across multiple IPs
 type ParallelDownloader struct {
-	clients   []*http.Client
-	baseURL   string
-	encMode   cbor.EncMode
-	sem       chan struct{} // semaphore for concurrent downloads
-	clientIdx atomic.Uint64 // round-robin index for client selection
+	*Client
+
+	sem chan struct{} // semaphore for concurrent downloads
 }
 
 // NewParallelDownloader creates a downloader that spreads requests across IPs
-func NewParallelDownloader(baseURL string, concurrency int) (*ParallelDownloader, error) {
-	clients, err := resolveClients(baseURL)
+func NewParallelDownloader(ctx context.Context, baseURL string, concurrency int) (*ParallelDownloader, error) {
+	client, err := NewClient(ctx, baseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	encMode, err := cbor.CanonicalEncOptions().EncMode()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cbor encoder: %w", err)
-	}
-
 	return &ParallelDownloader{
-		clients: clients,
-		baseURL: baseURL,
-		encMode: encMode,
-		sem:     make(chan struct{}, concurrency),
+		Client: client,
+		sem:    make(chan struct{}, concurrency),
 	}, nil
 }
 
-// DownloadBundle downloads a bundle using a round-robin client
-func (p *ParallelDownloader) DownloadBundle(key, domain, id string) (*BundleDownloadResponse, error) {
-	payload, err := encodeRequest(p.encMode, &BundleDownloadRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		ID: id,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
-
-	req, err := http.NewRequest("POST", p.baseURL+bundleDownloadEndpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", bundleDownloadEndpoint, err)
-	}
-
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, bundleDownloadEndpoint)
-	}
-
+// DownloadBundle downloads the bundle of an upload (the live one if id is empty)
+func (c *Client) DownloadBundle(ctx context.Context, req Request, id string) (*BundleDownloadResponse, error) {
 	var r BundleDownloadResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+	if err := c.call(ctx, bundleDownloadEndpoint, &BundleDownloadRequest{
+		Request: req,
+		ID:      id,
+	}, &r); err != nil {
 		return nil, err
 	}
 	return &r, nil
 }
 
-// DownloadParts downloads parts using a round-robin client selection
-func (p *ParallelDownloader) DownloadParts(key, domain string, hashes []Hash) (*PartsDownloadResponse, error) {
+// DownloadParts downloads parts using a round-robin client selection.
+// Received parts are matched to the requested hashes by their contents, whatever their order,
+// and returned in the order of hashes; parts missing or failing verification are requested again
+// following the retry policy, then reported as an *IntegrityError.
+func (p *ParallelDownloader) DownloadParts(ctx context.Context, req Request, hashes []Hash) (*PartsDownloadResponse, error) {
 	// Acquire semaphore
-	p.sem <- struct{}{}
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 	defer func() { <-p.sem }()
 
-	// Select client in round-robin fashion
-	clientIdx := int(p.clientIdx.Add(1)-1) % len(p.clients)
-	client := p.clients[clientIdx]
-
-	payload, err := encodeRequest(p.encMode, &PartsDownloadRequest{
-		Request: Request{
-			Key:    key,
-			Domain: domain,
-		},
-		Hashes: hashes,
-	})
-	if err != nil {
-		return nil, err
+	parts := make(map[Hash][]byte, len(hashes))
+	wanted := make(map[Hash]bool, len(hashes))
+	for _, h := range hashes {
+		wanted[h] = true
 	}
-
-	req, err := http.NewRequest("POST", p.baseURL+partsDownloadEndpoint, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	missing := hashes
+	var r PartsDownloadResponse
+	for n := 1; ; n++ {
+		r = PartsDownloadResponse{}
+		if err := p.call(ctx, partsDownloadEndpoint, &PartsDownloadRequest{
+			Request: req,
+			Hashes:  missing,
+		}, &r); err != nil {
+			return nil, err
+		}
+		if !r.Success {
+			return &r, nil
+		}
+		for _, part := range r.Parts {
+			if h := Hash(blake3.Sum256(part)); wanted[h] {
+				parts[h] = part
+			}
+		}
+		var next []Hash
+		for _, h := range missing {
+			if _, found := parts[h]; !found {
+				next = append(next, h)
+			}
+		}
+		missing = next
+		if len(missing) == 0 {
+			break
+		}
+		if n > p.Retry.Retries {
+			return nil, &IntegrityError{Hashes: missing}
+		}
+		d := p.Retry.delay(n, nil)
+		log.Printf("🔁 %d parts failed verification, retry %d/%d in %v…", len(missing), n, p.Retry.Retries, d.Round(time.Millisecond))
+		if err := sleep(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+	r.Parts = make([][]byte, len(hashes))
+	for i, h := range hashes {
+		r.Parts[i] = parts[h]
 	}
-	req.Header.Set("Content-Type", "application/cbor+zstd")
+	return &r, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to %s: %w", partsDownloadEndpoint, err)
+// ListTeams lists the teams the key of req can act for
+func (c *Client) ListTeams(ctx context.Context, req Request) (*ListTeamsResponse, error) {
+	var r ListTeamsResponse
+	if err := c.call(ctx, listTeamsEndpoint, &ListTeamsRequest{Request: req}, &r); err != nil {
+		return nil, err
 	}
+	return &r, nil
+}
 
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, partsDownloadEndpoint)
+// KeyInfo describes the key of req
+func (c *Client) KeyInfo(ctx context.Context, req Request) (*KeyInfoResponse, error) {
+	var r KeyInfoResponse
+	if err := c.call(ctx, keyInfoEndpoint, &KeyInfoRequest{Request: req}, &r); err != nil {
+		return nil, err
 	}
+	return &r, nil
+}
 
-	var r PartsDownloadResponse
-	if err = decodeResponse(resp.Body, &r); err != nil {
+// ListUploads lists the past uploads of the domain of req, most recent first
+func (c *Client) ListUploads(ctx context.Context, req Request, limit int) (*ListUploadsResponse, error) {
+	var r ListUploadsResponse
+	if err := c.call(ctx, listUploadsEndpoint, &ListUploadsRequest{Request: req, Limit: limit}, &r); err != nil {
 		return nil, err
 	}
 	return &r, nil
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/xmit-co/xmit/client"
+)
+
+// reporter logs events for humans and, optionally, writes them as newline-delimited JSON
+type reporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newReporter creates a reporter; w may be nil to only log
+func newReporter(w io.Writer) *reporter {
+	r := &reporter{}
+	if w != nil {
+		r.enc = json.NewEncoder(w)
+	}
+	return r
+}
+
+func (r *reporter) emit(e client.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logEvent(e)
+	if r.enc != nil {
+		if err := r.enc.Encode(e); err != nil {
+			log.Printf("⚠️ Failed to write event: %v", err)
+		}
+	}
+}
+
+func logEvent(e client.Event) {
+	switch e.Type {
+	case client.EventDiscovering:
+		log.Print("🔍 Discovering endpoint…")
+	case client.EventDiscovery:
+		log.Printf("🌐 Using URL: %s", e.URL)
+	case client.EventApproval:
+		log.Printf("🌍 Approve the key request in your browser: %s", e.URL)
+		log.Print("⏳ Waiting for approval…")
+	case client.EventBundling:
+		log.Printf("📦 Bundling %s…", e.Path)
+	case client.EventSkipped:
+		log.Printf("😇 Skipping %s", e.Path)
+	case client.EventBundle:
+		if e.Files > 0 {
+			log.Printf("🎁 Bundled %d files (%d bytes)", e.Files, e.Bytes)
+		}
+	case client.EventMessage:
+		switch e.Level {
+		case client.LevelError:
+			log.Printf("🛑 \033[91m%v\033[0m", e.Message)
+		case client.LevelWarning:
+			log.Printf("⚠️ \033[93m%v\033[0m", e.Message)
+		default:
+			log.Println(e.Message)
+		}
+	case client.EventWarning:
+		log.Printf("⚠️ %s", e.Message)
+	case client.EventPending:
+		log.Printf("📄 %s", e.Path)
+	case client.EventExtraneous:
+		log.Printf("🗑️ Would delete %s", e.Path)
+	case client.EventDryRun:
+		if e.Status == "download" {
+			log.Printf("🧪 Dry run: would download %d files (%d parts)", e.Files, e.Parts)
+			break
+		}
+		if e.Status == "new" {
+			log.Print("🆕 Bundle unknown to the server; every file may need uploading")
+		}
+		log.Printf("🧪 Dry run: would upload %d files (%d parts, %d bytes)", e.Files, e.Parts, e.Bytes)
+	case client.EventPromoting:
+		log.Printf("⏪ Promoting upload %s (bundle %s)", e.ID, e.Bundle)
+	case client.EventCompared:
+		if e.Files == 0 {
+			log.Print("🟰 No differences")
+		} else {
+			log.Printf("🔀 %d files differ: %s", e.Files, e.Message)
+		}
+	case client.EventFinalized:
+		log.Printf("🚀 Live with bundle %s", e.Bundle)
+	case client.EventDownloading:
+		log.Printf("🎁 Downloading %s", e.Path)
+	case client.EventFile:
+		log.Printf("✅ Downloaded %s", e.Path)
+	case client.EventDeleted:
+		log.Printf("🗑️ Deleted %s", e.Path)
+	case client.EventDownloaded:
+		log.Printf("🎉 Downloaded %d files (%d bytes)", e.Files, e.Bytes)
+	}
+}
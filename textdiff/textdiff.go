@@ -0,0 +1,279 @@
+// Package textdiff computes line-based differences between texts, in the unified format
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type op byte
+
+const (
+	equal op = iota
+	deleted
+	inserted
+)
+
+type edit struct {
+	op   op
+	line string
+}
+
+// Unified returns the unified diff turning old, named oldName, into new, named newName,
+// with context lines around changes; it is empty if the texts are equal
+func Unified(oldName, newName, old, new string, context int) string {
+	edits := diff(split(old), split(new))
+	hunks := group(edits, context)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		h.write(&b, edits)
+	}
+	return b.String()
+}
+
+// split cuts s into lines, keeping their line feeds
+func split(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxWork bounds the work spent looking for the middle snake of each subsequence; beyond it,
+// the subsequences are reported as entirely changed, so the diff stays valid but may not be minimal
+const maxWork = 1 << 26
+
+// diff computes an edit script with Myers' linear space algorithm
+func diff(a, b []string) []edit {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, found := ids[l]
+			if !found {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	n, m := len(a), len(b)
+	d := &differ{
+		a:       intern(a),
+		b:       intern(b),
+		deleted: make([]bool, n),
+		added:   make([]bool, m),
+		vf:      make([]int, 2*(n+m)+3),
+		vb:      make([]int, 2*(n+m)+3),
+	}
+	d.compare(0, n, 0, m)
+
+	edits := make([]edit, 0, max(n, m))
+	for x, y := 0, 0; x < n || y < m; {
+		switch {
+		case x < n && d.deleted[x]:
+			edits = append(edits, edit{deleted, a[x]})
+			x++
+		case y < m && d.added[y]:
+			edits = append(edits, edit{inserted, b[y]})
+			y++
+		default:
+			edits = append(edits, edit{equal, a[x]})
+			x++
+			y++
+		}
+	}
+	return edits
+}
+
+// differ marks the lines of a deleted and the lines of b added
+type differ struct {
+	a, b           []int // line IDs
+	deleted, added []bool
+	vf, vb         []int // furthest reaching paths by diagonal, forward and backward
+}
+
+// compare marks the changes between a[aLo:aHi] and b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		d.markAll(aLo, aHi, bLo, bHi)
+		return
+	}
+	x, y, found := d.middle(aLo, aHi, bLo, bHi)
+	if !found {
+		d.markAll(aLo, aHi, bLo, bHi)
+		return
+	}
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+func (d *differ) markAll(aLo, aHi, bLo, bHi int) {
+	for x := aLo; x < aHi; x++ {
+		d.deleted[x] = true
+	}
+	for y := bLo; y < bHi; y++ {
+		d.added[y] = true
+	}
+}
+
+// middle finds a point of a shortest edit path between a[aLo:aHi] and b[bLo:bHi] splitting it
+// into two cheaper paths, searching from both ends until the paths overlap
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	offset := len(d.vf) / 2
+	vf, vb := d.vf, d.vb
+	vf[offset+1], vb[offset+1] = 0, 0
+	limit := max(maxWork/(n+m), 64)
+	for D := 0; D <= (n+m+1)/2 && D <= limit; D++ {
+		// Forward, in coordinates relative to (aLo, bLo)
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if r := delta - k; odd && r >= -(D-1) && r <= D-1 && x+vb[offset+r] >= n {
+				return aLo + startX, bLo + startY, true
+			}
+		}
+		// Backward, in coordinates relative to (aHi, bHi) going down
+		for r := -D; r <= D; r += 2 {
+			var x int
+			if r == -D || (r != D && vb[offset+r-1] < vb[offset+r+1]) {
+				x = vb[offset+r+1]
+			} else {
+				x = vb[offset+r-1] + 1
+			}
+			y := x - r
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			vb[offset+r] = x
+			if k := delta - r; !odd && k >= -D && k <= D && x+vf[offset+k] >= n {
+				return aHi - startX, bHi - startY, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// hunk is a range of edits, starting at the given (zero-based) lines of both texts
+type hunk struct {
+	start, end         int
+	oldStart, newStart int
+}
+
+// group gathers changes no more than 2*context lines apart into hunks
+func group(edits []edit, context int) []hunk {
+	var hunks []hunk
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].op == equal {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		// Back up over the leading context
+		lead := 0
+		for lead < context && i-lead > 0 && edits[i-lead-1].op == equal {
+			lead++
+		}
+		h := hunk{start: i - lead, oldStart: oldLine - lead, newStart: newLine - lead}
+		// Extend over changes and the equal runs short enough to join them
+		for i < len(edits) {
+			if edits[i].op != equal {
+				if edits[i].op == deleted {
+					oldLine++
+				} else {
+					newLine++
+				}
+				i++
+				continue
+			}
+			run := 0
+			for i+run < len(edits) && edits[i+run].op == equal {
+				run++
+			}
+			if i+run < len(edits) && run <= 2*context {
+				oldLine += run
+				newLine += run
+				i += run
+				continue
+			}
+			trail := min(run, context)
+			oldLine += trail
+			newLine += trail
+			i += trail
+			break
+		}
+		h.end = i
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+func (h hunk) write(b *strings.Builder, edits []edit) {
+	oldCount, newCount := 0, 0
+	for _, e := range edits[h.start:h.end] {
+		if e.op != inserted {
+			oldCount++
+		}
+		if e.op != deleted {
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", lineRange(h.oldStart, oldCount), lineRange(h.newStart, newCount))
+	for _, e := range edits[h.start:h.end] {
+		switch e.op {
+		case equal:
+			b.WriteByte(' ')
+		case deleted:
+			b.WriteByte('-')
+		case inserted:
+			b.WriteByte('+')
+		}
+		b.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// lineRange formats a hunk range the way diff -u does
+func lineRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}